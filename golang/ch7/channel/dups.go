@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// partialSize 是第一轮只计算文件开头部分哈希时读取的字节数
+const partialSize = 4096
+
+// dupSet 是一组内容完全相同的文件
+type dupSet struct {
+	Size  int64    `json:"size"`
+	Paths []string `json:"paths"`
+}
+
+// reclaimable 返回只保留一个副本时可以释放的字节数
+func (d *dupSet) reclaimable() int64 {
+	return d.Size * int64(len(d.Paths)-1)
+}
+
+type hashJob struct {
+	group int
+	path  string
+}
+
+type hashResult struct {
+	hashJob
+	sum string
+	err error
+}
+
+// findDups 在按大小分好的组里找出重复文件：先比较开头partialSize字节的哈希，
+// 剩下的候选再比较完整的SHA-256
+func findDups(bySize map[int64][]string) (sets []*dupSet, reclaimable int64) {
+	var candidates []*dupSet
+	for size, paths := range bySize {
+		if len(paths) > 1 {
+			candidates = append(candidates, &dupSet{Size: size, Paths: paths})
+		}
+	}
+
+	candidates = groupByHash(candidates, partialSize)
+	var small, large []*dupSet
+	for _, d := range candidates {
+		// 不超过partialSize的文件第一轮已经是完整哈希
+		if d.Size <= partialSize {
+			small = append(small, d)
+		} else {
+			large = append(large, d)
+		}
+	}
+	sets = append(small, groupByHash(large, 0)...)
+
+	sort.Slice(sets, func(i, j int) bool {
+		if ri, rj := sets[i].reclaimable(), sets[j].reclaimable(); ri != rj {
+			return ri > rj
+		}
+		return sets[i].Paths[0] < sets[j].Paths[0]
+	})
+	for _, d := range sets {
+		reclaimable += d.reclaimable()
+	}
+	return sets, reclaimable
+}
+
+// groupByHash 用一组worker并发计算每个文件的哈希，把每组再按哈希拆分，
+// 只返回仍有多个文件的组。limit大于0时只读取文件开头的limit字节
+func groupByHash(groups []*dupSet, limit int64) []*dupSet {
+	jobs := make(chan hashJob)
+	results := make(chan hashResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				sum, err := hashFile(job.path, limit)
+				results <- hashResult{job, sum, err}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i, d := range groups {
+			for _, path := range d.Paths {
+				select {
+				case jobs <- hashJob{i, path}:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	type key struct {
+		group int
+		sum   string
+	}
+	buckets := make(map[key][]string)
+	for r := range results {
+		if r.err != nil {
+			fmt.Fprintf(os.Stderr, "du: %v\n", r.err)
+			continue
+		}
+		k := key{r.group, r.sum}
+		buckets[k] = append(buckets[k], r.path)
+	}
+
+	var out []*dupSet
+	for k, paths := range buckets {
+		if len(paths) > 1 {
+			sort.Strings(paths)
+			out = append(out, &dupSet{Size: groups[k.group].Size, Paths: paths})
+		}
+	}
+	return out
+}
+
+func hashFile(path string, limit int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if limit > 0 {
+		_, err = io.CopyN(h, f, limit)
+		if err == io.EOF {
+			err = nil
+		}
+	} else {
+		_, err = io.Copy(h, f)
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func printDups(w io.Writer, sets []*dupSet, reclaimable int64) {
+	fmt.Fprintf(w, "\nduplicate files:\n")
+	for _, d := range sets {
+		fmt.Fprintf(w, "%s x %d, reclaimable %s\n", formatSize(d.Size), len(d.Paths), formatSize(d.reclaimable()))
+		for _, path := range d.Paths {
+			fmt.Fprintf(w, "  %s\n", path)
+		}
+	}
+	fmt.Fprintf(w, "%d duplicate sets, %s reclaimable\n", len(sets), formatSize(reclaimable))
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
 //func main() {
@@ -70,48 +72,103 @@ func main() {
 	lanunch()
 }*/
 
-func walkDir(dir string, fileSizes chan<- int64) {
-	for _, entry := range dirents(dir) {
-		if entry.IsDir() {
-			subdir := filepath.Join(dir, entry.Name())
-			walkDir(subdir, fileSizes)
-		} else {
-			fileSizes <- entry.Size()
-		}
-	}
-}
-
-func dirents(dir string) []os.FileInfo {
-	entries, err := ioutil.ReadDir(dir)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "du1: %v\n", err)
-		return nil
-	}
-	return entries
-}
+var (
+	verbose  = flag.Bool("v", false, "show verbose progress messages")
+	depth    = flag.Int("depth", 0, "show per-directory breakdown down to this depth")
+	top      = flag.Int("top", 0, "list the N largest directories and files")
+	jsonFlag = flag.Bool("json", false, "print the result as JSON")
+	dups     = flag.Bool("dups", false, "find duplicate files by content")
+	follow   = flag.Bool("L", false, "follow symbolic links")
+	oneFS    = flag.Bool("x", false, "skip directories on different file systems")
+	excludes patterns
+)
 
 func main() {
+	flag.Var(&excludes, "exclude", "skip files and directories matching the glob `pattern` (repeatable)")
 	flag.Parse()
 	roots := flag.Args()
 	if len(roots) == 0 {
 		roots = []string{"."}
 	}
-	fileSizes := make(chan int64)
+	for i := range roots {
+		roots[i] = filepath.Clean(roots[i])
+		info, err := os.Stat(roots[i])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "du: %v\n", err)
+			continue
+		}
+		if id, _, ok := statID(info); ok {
+			rootDevs[roots[i]] = id.dev
+			if *follow {
+				markVisited(id)
+			}
+		}
+	}
+
+	// 标准输入收到内容时取消遍历，stdin为空(EOF)时不取消
 	go func() {
-		for _, root := range roots {
-			walkDir(root, fileSizes)
+		if n, _ := os.Stdin.Read(make([]byte, 1)); n > 0 {
+			close(done)
 		}
-		close(fileSizes)
 	}()
 
-	var nfiles, nbytes int64
-	for size := range fileSizes {
-		nfiles++
-		nbytes += size
+	files := make(chan fileEntry)
+	var n sync.WaitGroup
+	for _, root := range roots {
+		n.Add(1)
+		go walkDir(root, root, &n, files)
 	}
-	printDiskUsage(nfiles, nbytes)
-}
+	go func() {
+		n.Wait()
+		close(files)
+	}()
 
-func printDiskUsage(nfiles, nbytes int64) {
-	fmt.Printf("%d files %.1f GB\n", nfiles, float64(nbytes)/1e9)
+	// -json模式下进度输出到标准错误，避免破坏JSON
+	var progress io.Writer = os.Stdout
+	if *jsonFlag {
+		progress = os.Stderr
+	}
+
+	// 定期输出当前的统计结果
+	var tick <-chan time.Time
+	if *verbose {
+		tick = time.Tick(500 * time.Millisecond)
+	}
+	c := newCollector(roots, *top, *dups)
+loop:
+	for {
+		select {
+		case <-done:
+			// 排空files，让已经开始的goroutine可以结束
+			for range files {
+			}
+			return
+		case f, ok := <-files:
+			if !ok {
+				break loop
+			}
+			c.add(f)
+		case <-tick:
+			printDiskUsage(progress, c.nfiles, c.nbytes)
+		}
+	}
+
+	r := c.report(*depth)
+	if *dups {
+		r.Duplicates, r.Reclaimable = findDups(c.bySize)
+		if cancelled() {
+			return
+		}
+	}
+	if *jsonFlag {
+		if err := printJSON(os.Stdout, r); err != nil {
+			fmt.Fprintf(os.Stderr, "du: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	printReport(os.Stdout, r)
+	if *dups {
+		printDups(os.Stdout, r.Duplicates, r.Reclaimable)
+	}
 }
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// usage 是一个目录或文件的占用统计
+type usage struct {
+	Path     string   `json:"path"`
+	Files    int64    `json:"files"`
+	Bytes    int64    `json:"bytes"`
+	Children []*usage `json:"children,omitempty"`
+}
+
+// report 是-json模式下输出的完整结果
+type report struct {
+	Roots    []*usage `json:"roots"`
+	Files    int64    `json:"files"`
+	Bytes    int64    `json:"bytes"`
+	TopDirs  []*usage `json:"top_dirs,omitempty"`
+	TopFiles []*usage `json:"top_files,omitempty"`
+
+	Duplicates  []*dupSet `json:"duplicates,omitempty"`
+	Reclaimable int64     `json:"reclaimable,omitempty"`
+}
+
+// collector 汇总walkDir发现的文件，只在main goroutine中使用
+type collector struct {
+	roots  []string
+	trees  map[string]map[string]*usage // root -> 目录 -> 统计(包含子目录)
+	seen   map[fileID]bool              // 已经统计过的硬链接文件
+	files  fileHeap                     // 最大的top个文件
+	top    int
+	bySize map[int64][]string // -dups时按大小分组的文件
+	nfiles int64
+	nbytes int64
+}
+
+func newCollector(roots []string, top int, dups bool) *collector {
+	c := &collector{
+		roots: roots,
+		trees: make(map[string]map[string]*usage),
+		seen:  make(map[fileID]bool),
+		top:   top,
+	}
+	if dups {
+		c.bySize = make(map[int64][]string)
+	}
+	for _, root := range roots {
+		if c.trees[root] == nil {
+			c.trees[root] = map[string]*usage{root: {Path: root}}
+		}
+	}
+	return c
+}
+
+func (c *collector) add(f fileEntry) {
+	// 同一个文件的多个硬链接只统计一次
+	if f.hardLink {
+		if c.seen[f.id] {
+			return
+		}
+		c.seen[f.id] = true
+	}
+	c.nfiles++
+	c.nbytes += f.size
+
+	// 文件大小累加到所在目录以及直到root的每一级父目录
+	dirs := c.trees[f.root]
+	for d := f.dir; ; {
+		u := dirs[d]
+		if u == nil {
+			u = &usage{Path: d}
+			dirs[d] = u
+		}
+		u.Files++
+		u.Bytes += f.size
+		parent := filepath.Dir(d)
+		if d == f.root || parent == d {
+			break
+		}
+		d = parent
+	}
+
+	// 只比较非空的普通文件
+	if c.bySize != nil && f.regular && f.size > 0 {
+		c.bySize[f.size] = append(c.bySize[f.size], filepath.Join(f.dir, f.name))
+	}
+
+	if c.top > 0 {
+		heap.Push(&c.files, &usage{Path: filepath.Join(f.dir, f.name), Files: 1, Bytes: f.size})
+		if c.files.Len() > c.top {
+			heap.Pop(&c.files)
+		}
+	}
+}
+
+// tree 返回root的统计，子目录展开到depth层
+func (c *collector) tree(root string, depth int) *usage {
+	dirs := c.trees[root]
+	for _, u := range dirs {
+		u.Children = nil
+	}
+	for path, u := range dirs {
+		if path == root || pathDepth(root, path) > depth {
+			continue
+		}
+		parent := dirs[filepath.Dir(path)]
+		parent.Children = append(parent.Children, u)
+	}
+	for _, u := range dirs {
+		sortUsage(u.Children)
+	}
+	return dirs[root]
+}
+
+// topDirs 返回所有root下(不含root本身)最大的n个目录
+func (c *collector) topDirs(n int) []*usage {
+	var all []*usage
+	for root, dirs := range c.trees {
+		for path, u := range dirs {
+			if path != root {
+				all = append(all, &usage{Path: u.Path, Files: u.Files, Bytes: u.Bytes})
+			}
+		}
+	}
+	sortUsage(all)
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// topFiles 返回最大的top个文件，从大到小排列
+func (c *collector) topFiles() []*usage {
+	files := append([]*usage(nil), c.files...)
+	sortUsage(files)
+	return files
+}
+
+func (c *collector) report(depth int) *report {
+	r := &report{Files: c.nfiles, Bytes: c.nbytes}
+	for _, root := range c.roots {
+		r.Roots = append(r.Roots, c.tree(root, depth))
+	}
+	if c.top > 0 {
+		r.TopDirs = c.topDirs(c.top)
+		r.TopFiles = c.topFiles()
+	}
+	return r
+}
+
+func printReport(w io.Writer, r *report) {
+	for _, root := range r.Roots {
+		printTree(w, root, 0)
+	}
+	if len(r.Roots) > 1 {
+		fmt.Fprintf(w, "total: ")
+		printDiskUsage(w, r.Files, r.Bytes)
+	}
+	if len(r.TopDirs) > 0 {
+		fmt.Fprintf(w, "\nlargest directories:\n")
+		for _, u := range r.TopDirs {
+			fmt.Fprintf(w, "%10s  %s\n", formatSize(u.Bytes), u.Path)
+		}
+	}
+	if len(r.TopFiles) > 0 {
+		fmt.Fprintf(w, "\nlargest files:\n")
+		for _, u := range r.TopFiles {
+			fmt.Fprintf(w, "%10s  %s\n", formatSize(u.Bytes), u.Path)
+		}
+	}
+}
+
+func printTree(w io.Writer, u *usage, level int) {
+	fmt.Fprintf(w, "%s%s: ", strings.Repeat("  ", level), u.Path)
+	printDiskUsage(w, u.Files, u.Bytes)
+	for _, child := range u.Children {
+		printTree(w, child, level+1)
+	}
+}
+
+func printJSON(w io.Writer, r *report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func printDiskUsage(w io.Writer, nfiles, nbytes int64) {
+	fmt.Fprintf(w, "%d files %s\n", nfiles, formatSize(nbytes))
+}
+
+// formatSize 根据大小自动选择单位
+func formatSize(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	units := []string{"KB", "MB", "GB", "TB"}
+	size := float64(n) / unit
+	i := 0
+	for size >= unit && i < len(units)-1 {
+		size /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", size, units[i])
+}
+
+// pathDepth 返回path相对root的层数
+func pathDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+func sortUsage(us []*usage) {
+	sort.Slice(us, func(i, j int) bool {
+		if us[i].Bytes != us[j].Bytes {
+			return us[i].Bytes > us[j].Bytes
+		}
+		return us[i].Path < us[j].Path
+	})
+}
+
+// fileHeap 是按大小排列的最小堆，用来保留最大的N个文件
+type fileHeap []*usage
+
+func (h fileHeap) Len() int            { return len(h) }
+func (h fileHeap) Less(i, j int) bool  { return h[i].Bytes < h[j].Bytes }
+func (h fileHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *fileHeap) Push(x interface{}) { *h = append(*h, x.(*usage)) }
+func (h *fileHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
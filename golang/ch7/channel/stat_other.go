@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// 非unix平台拿不到inode，不做硬链接去重、环检测和文件系统边界判断
+func statID(info os.FileInfo) (id fileID, nlink uint64, ok bool) {
+	return fileID{}, 0, false
+}
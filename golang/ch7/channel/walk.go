@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// sema 是一个计数信号量，用来限制同时打开的目录数，避免耗尽文件描述符
+var sema = make(chan struct{}, 20)
+
+// done 在标准输入收到内容后关闭，用来通知所有goroutine取消
+var done = make(chan struct{})
+
+// rootDevs 记录每个root所在的设备，-x时用来判断是否跨越文件系统，遍历开始后只读
+var rootDevs = make(map[string]uint64)
+
+// visited 记录-L时已经进入过的目录，避免符号链接造成的环
+var visited = struct {
+	sync.Mutex
+	dirs map[fileID]bool
+}{dirs: make(map[fileID]bool)}
+
+// fileID 唯一标识一个文件(设备号+inode)
+type fileID struct {
+	dev, ino uint64
+}
+
+// fileEntry 是walkDir发现的一个文件
+type fileEntry struct {
+	root     string // 所属的根目录
+	dir      string // 所在目录
+	name     string
+	size     int64
+	id       fileID
+	hardLink bool // 有多个硬链接，需要按id去重
+	regular  bool // 普通文件，符号链接、设备文件等为false
+}
+
+// patterns 是可以重复指定的-exclude参数
+type patterns []string
+
+func (p *patterns) String() string { return strings.Join(*p, ",") }
+
+func (p *patterns) Set(s string) error {
+	if _, err := filepath.Match(s, ""); err != nil {
+		return err
+	}
+	*p = append(*p, s)
+	return nil
+}
+
+// excluded 判断文件名或完整路径是否匹配某个排除模式
+func excluded(path, name string) bool {
+	for _, p := range excludes {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// markVisited 记录目录已进入，目录之前已经进入过时返回false
+func markVisited(id fileID) bool {
+	visited.Lock()
+	defer visited.Unlock()
+	if visited.dirs[id] {
+		return false
+	}
+	visited.dirs[id] = true
+	return true
+}
+
+// enterDir 判断是否进入root下的某个子目录
+func enterDir(root string, info os.FileInfo) bool {
+	id, _, ok := statID(info)
+	if !ok {
+		return true
+	}
+	if *oneFS && id.dev != rootDevs[root] {
+		return false
+	}
+	if *follow {
+		return markVisited(id)
+	}
+	return true
+}
+
+func cancelled() bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+func walkDir(root, dir string, n *sync.WaitGroup, files chan<- fileEntry) {
+	defer n.Done()
+	if cancelled() {
+		return
+	}
+	for _, entry := range dirents(dir) {
+		path := filepath.Join(dir, entry.Name())
+		if excluded(path, entry.Name()) {
+			continue
+		}
+		info := entry
+		if *follow && entry.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "du: %v\n", err)
+				continue
+			}
+			info = target
+		}
+		if info.IsDir() {
+			if !enterDir(root, info) {
+				continue
+			}
+			n.Add(1)
+			go walkDir(root, path, n, files)
+		} else {
+			id, nlink, ok := statID(info)
+			select {
+			case files <- fileEntry{root, dir, entry.Name(), info.Size(), id, ok && nlink > 1, info.Mode().IsRegular()}:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+func dirents(dir string) []os.FileInfo {
+	select {
+	case sema <- struct{}{}: // 获取令牌
+	case <-done:
+		return nil
+	}
+	defer func() { <-sema }() // 释放令牌
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "du: %v\n", err)
+		return nil
+	}
+	return entries
+}
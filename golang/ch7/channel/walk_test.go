@@ -0,0 +1,161 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// walk 按main的方式遍历root，返回汇总结果。followLinks对应-L，
+// exclude对应-exclude，测试结束后恢复这些全局状态
+func walk(t *testing.T, root string, followLinks bool, exclude ...string) *collector {
+	t.Helper()
+	oldFollow, oldExcludes := *follow, excludes
+	t.Cleanup(func() {
+		*follow, excludes = oldFollow, oldExcludes
+	})
+	*follow, excludes = followLinks, exclude
+	rootDevs = make(map[string]uint64)
+	visited.dirs = make(map[fileID]bool)
+
+	info, err := os.Stat(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _, ok := statID(info); ok {
+		rootDevs[root] = id.dev
+		if *follow {
+			markVisited(id)
+		}
+	}
+
+	files := make(chan fileEntry)
+	var n sync.WaitGroup
+	n.Add(1)
+	go walkDir(root, root, &n, files)
+	go func() {
+		n.Wait()
+		close(files)
+	}()
+	c := newCollector([]string{root}, 0, true)
+	for f := range files {
+		c.add(f)
+	}
+	return c
+}
+
+// writeFile 创建大小为size的文件，父目录不存在时一并创建
+func writeFile(t *testing.T, path string, size int) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalkHardLink(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a"), 100)
+	if err := os.Link(filepath.Join(root, "a"), filepath.Join(root, "b")); err != nil {
+		t.Skip(err)
+	}
+	c := walk(t, root, false)
+	if c.nfiles != 1 || c.nbytes != 100 {
+		t.Errorf("got %d files %d bytes, want 1 file 100 bytes", c.nfiles, c.nbytes)
+	}
+}
+
+func TestWalkSymlinkLoop(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "sub", "f"), 10)
+	// sub/up指回root，-L时不能无限递归
+	if err := os.Symlink("..", filepath.Join(root, "sub", "up")); err != nil {
+		t.Fatal(err)
+	}
+	c := walk(t, root, true)
+	if c.nfiles != 1 || c.nbytes != 10 {
+		t.Errorf("got %d files %d bytes, want 1 file 10 bytes", c.nfiles, c.nbytes)
+	}
+}
+
+func TestWalkExclude(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "keep.txt"), 10)
+	writeFile(t, filepath.Join(root, "skip.log"), 20)
+	writeFile(t, filepath.Join(root, "cache", "big"), 40)
+	c := walk(t, root, false, "*.log", filepath.Join(root, "cache"))
+	if c.nfiles != 1 || c.nbytes != 10 {
+		t.Errorf("got %d files %d bytes, want 1 file 10 bytes", c.nfiles, c.nbytes)
+	}
+	if _, ok := c.trees[root][filepath.Join(root, "cache")]; ok {
+		t.Error("excluded directory was walked")
+	}
+}
+
+func TestWalkSymlinkDirNotFollowed(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "dir", "f"), 10)
+	if err := os.Symlink("dir", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	c := walk(t, root, false)
+	// 没有-L时符号链接本身作为一个文件统计，不进入它指向的目录
+	if c.nfiles != 2 {
+		t.Errorf("got %d files, want 2 (f and the link itself)", c.nfiles)
+	}
+	if _, ok := c.trees[root][filepath.Join(root, "link")]; ok {
+		t.Error("symlinked directory was walked without -L")
+	}
+	for size, paths := range c.bySize {
+		if size != 10 || len(paths) != 1 {
+			t.Errorf("bySize[%d] = %v, want only the regular file", size, paths)
+		}
+	}
+}
+
+func TestFindDups(t *testing.T) {
+	root := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(root, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	big := bytes.Repeat([]byte("a"), partialSize+1000)
+	otherTail := append(bytes.Repeat([]byte("a"), partialSize), bytes.Repeat([]byte("b"), 1000)...)
+	big1 := write("big1", big)
+	big2 := write("big2", big)
+	write("big3", otherTail) // 开头partialSize字节相同，结尾不同
+	small1 := write("small1", []byte("hello"))
+	small2 := write("small2", []byte("hello"))
+	write("small3", []byte("world")) // 大小相同，内容不同
+	// 同一个文件的两个硬链接不算重复
+	linked := write("linked", bytes.Repeat([]byte("c"), 100))
+	if err := os.Link(linked, filepath.Join(root, "linked2")); err != nil {
+		t.Skip(err)
+	}
+
+	c := walk(t, root, false)
+	sets, reclaimable := findDups(c.bySize)
+	want := []*dupSet{
+		{Size: int64(len(big)), Paths: []string{big1, big2}},
+		{Size: 5, Paths: []string{small1, small2}},
+	}
+	if !reflect.DeepEqual(sets, want) {
+		t.Errorf("findDups() = %v, want %v", sets, want)
+		for _, d := range sets {
+			t.Logf("  %d %v", d.Size, d.Paths)
+		}
+	}
+	if reclaimable != int64(len(big))+5 {
+		t.Errorf("reclaimable = %d, want %d", reclaimable, len(big)+5)
+	}
+}
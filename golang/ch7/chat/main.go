@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	idle  = flag.Duration("idle", 5*time.Minute, "disconnect clients that send nothing for this long")
+	queue = flag.Int("queue", 16, "outgoing messages buffered per client before dropping")
+)
+
+// 写超时，避免一个不读数据的客户端让它的写goroutine一直阻塞
+const writeTimeout = 10 * time.Second
+
+func main() {
+	flag.Parse()
+	l, err := net.Listen("tcp", "localhost:8000")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go broadcaster()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		go handleConn(conn)
+	}
+}
+
+type client struct {
+	out  chan string // 发往客户端的消息，带缓冲
+	name string      // 只由broadcaster读写
+}
+
+// rename 是客户端的/nick请求，broadcaster通过ok回复是否成功
+type rename struct {
+	cli  *client
+	name string
+	ok   chan bool
+}
+
+var (
+	entering = make(chan *client)
+	leaving  = make(chan *client)
+	messages = make(chan string) // 所有客户端的消息
+	renames  = make(chan rename)
+)
+
+func broadcaster() {
+	clients := make(map[*client]bool)
+	for {
+		select {
+		case msg := <-messages:
+			// 把消息广播给所有客户端的发送队列
+			for cli := range clients {
+				send(cli, msg)
+			}
+		case cli := <-entering:
+			var names []string
+			for c := range clients {
+				names = append(names, c.name)
+			}
+			if len(names) > 0 {
+				sort.Strings(names)
+				send(cli, "online: "+strings.Join(names, ", "))
+			}
+			clients[cli] = true
+		case cli := <-leaving:
+			delete(clients, cli)
+			close(cli.out)
+		case r := <-renames:
+			taken := false
+			for c := range clients {
+				if c.name == r.name {
+					taken = true
+					break
+				}
+			}
+			if taken {
+				send(r.cli, r.name+" is already taken")
+				r.ok <- false
+				continue
+			}
+			old := r.cli.name
+			r.cli.name = r.name
+			r.ok <- true
+			for c := range clients {
+				send(c, old+" is now "+r.name)
+			}
+		}
+	}
+}
+
+// send 不会阻塞，客户端的发送队列满了就丢弃这条消息，慢客户端不会拖住其他人
+func send(cli *client, msg string) {
+	select {
+	case cli.out <- msg:
+	default:
+		log.Printf("%s: queue full, dropping message", cli.name)
+	}
+}
+
+func handleConn(conn net.Conn) {
+	who := conn.RemoteAddr().String()
+	cli := &client{out: make(chan string, *queue), name: who}
+	go clientWriter(conn, cli.out)
+
+	send(cli, "You are "+who)
+	entering <- cli
+	messages <- who + " has arrived"
+
+	input := bufio.NewScanner(conn)
+	for {
+		// 超过idle时间没有输入的客户端会被踢出
+		conn.SetReadDeadline(time.Now().Add(*idle))
+		if !input.Scan() {
+			break
+		}
+		text := input.Text()
+		if strings.HasPrefix(text, "/nick ") {
+			name := strings.TrimSpace(strings.TrimPrefix(text, "/nick "))
+			if name == "" {
+				continue
+			}
+			ok := make(chan bool)
+			renames <- rename{cli, name, ok}
+			if <-ok {
+				who = name
+			}
+			continue
+		}
+		messages <- who + ": " + text
+	}
+	if err := input.Err(); err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			send(cli, "disconnected: idle for "+idle.String())
+		} else {
+			fmt.Fprintf(os.Stderr, "chat: %s: %v\n", who, err)
+		}
+	}
+
+	leaving <- cli
+	messages <- who + " has left"
+}
+
+// clientWriter 把发送队列里的消息写到连接，队列关闭后关闭连接
+func clientWriter(conn net.Conn, ch <-chan string) {
+	defer conn.Close()
+	for msg := range ch {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := fmt.Fprintln(conn, msg); err != nil {
+			// 写失败后关闭连接让handleConn退出，继续取出消息直到队列关闭
+			conn.Close()
+			for range ch {
+			}
+			return
+		}
+	}
+}
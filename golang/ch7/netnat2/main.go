@@ -1,24 +1,64 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"io"
 	"log"
 	"net"
 	"os"
+	"time"
 )
 
+var timeout = flag.Duration("timeout", 0, "how long to wait for the server to finish after stdin EOF (0 waits forever)")
+
+// errTimeout 表示stdin结束后超过-timeout服务端还没有关闭连接
+var errTimeout = errors.New("timed out waiting for server")
+
 func main() {
+	flag.Parse()
 	conn, err := net.Dial("tcp", "localhost:8000")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer conn.Close()
-	go mustCopy(os.Stdout, conn)
-	mustCopy(conn, os.Stdin)
+	if err := run(conn, os.Stdin, os.Stdout, *timeout); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func mustCopy(dst io.Writer, src io.Reader) {
-	if _, err := io.Copy(dst, src); err != nil {
-		log.Fatal(err)
+// run 把stdin发给服务端，同时把服务端的输出写到stdout。stdin结束后关闭写端，
+// 等服务端关闭连接或者超过timeout(0表示一直等)，返回前关闭conn
+func run(conn net.Conn, stdin io.Reader, stdout io.Writer, timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		// 一直读到服务端关闭连接，服务端延迟的回声也不会丢
+		if _, err := io.Copy(stdout, conn); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Print(err)
+		}
+		close(done)
+	}()
+	// 返回前关闭连接并等读goroutine结束，之后不会再写stdout
+	defer func() {
+		conn.Close()
+		<-done
+	}()
+	if _, err := io.Copy(conn, stdin); err != nil {
+		return err
+	}
+
+	// stdin结束后只关闭写端，通知服务端没有更多输入
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	select {
+	case <-done:
+		return nil
+	case <-expired:
+		return errTimeout
 	}
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"gopl.io/ch7/reverb/echo"
+)
+
+// reverb 在回环地址上用ch7/reverb的连接处理逻辑启动服务端，回声间隔为delay。返回服务端地址
+func reverb(t *testing.T, delay time.Duration) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go echo.HandleConn(c, 10*time.Second, delay)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func dialReverb(t *testing.T, delay time.Duration) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", reverb(t, delay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestRunDrainsAfterStdinEOF(t *testing.T) {
+	conn := dialReverb(t, 50*time.Millisecond)
+	var out bytes.Buffer
+	if err := run(conn, strings.NewReader("Hello\nGo\n"), &out, 0); err != nil {
+		t.Fatal(err)
+	}
+	// stdin结束时后两次回声还没有发出，半关闭之后仍然要收到全部回声
+	for _, want := range []string{"HELLO", "Hello", "hello", "GO", "Go", "go"} {
+		if !strings.Contains(out.String(), "\t "+want+"\n") {
+			t.Errorf("output %q is missing %q", out.String(), want)
+		}
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	conn := dialReverb(t, time.Second)
+	var out bytes.Buffer
+	start := time.Now()
+	err := run(conn, strings.NewReader("Hello\n"), &out, 100*time.Millisecond)
+	if err != errTimeout {
+		t.Fatalf("run() error = %v, want %v", err, errTimeout)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("run() returned after %v, want about 100ms", d)
+	}
+}
@@ -0,0 +1,56 @@
+// Package echo 是reverb服务端的连接处理逻辑
+package echo
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// connWriter 串行化同一个连接上的写操作，避免并发的echo输出交错
+type connWriter struct {
+	mu sync.Mutex
+	c  net.Conn
+}
+
+func (w *connWriter) println(a ...interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fmt.Fprintln(w.c, a...)
+}
+
+func echo(w *connWriter, shout string, delay time.Duration, wg *sync.WaitGroup) {
+	defer wg.Done()
+	w.println("\t", strings.ToUpper(shout))
+	time.Sleep(delay)
+	w.println("\t", shout)
+	time.Sleep(delay)
+	w.println("\t", strings.ToLower(shout))
+}
+
+// HandleConn 对c上的每一行输入回声三次，每次间隔delay。
+// 客户端超过idle没有输入或者关闭写端之后，等所有回声结束再关闭连接
+func HandleConn(c net.Conn, idle, delay time.Duration) {
+	w := &connWriter{c: c}
+	var wg sync.WaitGroup
+	input := bufio.NewScanner(c)
+	for {
+		// 客户端超过idle时间没有输入就断开
+		c.SetReadDeadline(time.Now().Add(idle))
+		if !input.Scan() {
+			break
+		}
+		wg.Add(1)
+		go echo(w, input.Text(), delay, &wg)
+	}
+	if err := input.Err(); err != nil {
+		log.Printf("%s: %v", c.RemoteAddr(), err)
+	}
+	// 等待所有还没结束的echo，再关闭连接
+	wg.Wait()
+	c.Close()
+}
@@ -1,15 +1,18 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
+	"flag"
 	"log"
 	"net"
-	"strings"
 	"time"
+
+	"gopl.io/ch7/reverb/echo"
 )
 
+var idle = flag.Duration("idle", 30*time.Second, "disconnect clients that send nothing for this long")
+
 func main() {
+	flag.Parse()
 	l, err := net.Listen("tcp", "localhost:8000")
 	if err != nil {
 		log.Fatal(err)
@@ -20,22 +23,6 @@ func main() {
 			log.Print(err)
 			continue
 		}
-		go handleConn(conn)
-	}
-}
-
-func echo(c net.Conn, shout string, delay time.Duration) {
-	fmt.Fprintln(c, "\t", strings.ToUpper(shout))
-	time.Sleep(delay)
-	fmt.Fprintln(c, "\t", shout)
-	time.Sleep(delay)
-	fmt.Fprintln(c, "\t", strings.ToLower(shout))
-}
-
-func handleConn(c net.Conn) {
-	input := bufio.NewScanner(c)
-	for input.Scan() {
-		go echo(c, input.Text(), 1*time.Second)
+		go echo.HandleConn(conn, *idle, 1*time.Second)
 	}
-	c.Close()
 }
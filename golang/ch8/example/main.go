@@ -1,16 +1,29 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"sync"
 	"time"
+
+	"gopl.io/ch8/example/memo"
 )
 
+var monitor = flag.Bool("monitor", false, "use the monitor-goroutine cache instead of the mutex one")
+
 func main() {
-	m := New(httpGetBody)
+	flag.Parse()
+	var m memo.Cache[string, []byte]
+	if *monitor {
+		mm := memo.NewMonitor(httpGetBody)
+		defer mm.Close()
+		m = mm
+	} else {
+		m = memo.New(httpGetBody)
+	}
 	var n sync.WaitGroup
 
 	for url := range incomingURLs() {
@@ -22,7 +35,7 @@ func main() {
 			if err != nil {
 				log.Print(err)
 			}
-			fmt.Printf("%s, %s, %d bytes\n", url, time.Since(start), len(value.([]byte)))
+			fmt.Printf("%s, %s, %d bytes\n", url, time.Since(start), len(value))
 			n.Done()
 		}(url)
 
@@ -30,7 +43,7 @@ func main() {
 	n.Wait()
 }
 
-func httpGetBody(url string) (interface{}, error) {
+func httpGetBody(url string) ([]byte, error) {
 	resp, err := http.Get(url)
 	if err != nil {
 		return nil, err
@@ -39,35 +52,6 @@ func httpGetBody(url string) (interface{}, error) {
 	return ioutil.ReadAll(resp.Body)
 }
 
-type Memo struct {
-	f     Func
-	mu    sync.Mutex
-	cache map[string]result
-}
-
-type Func func(key string) (interface{}, error)
-type result struct {
-	value interface{}
-	err   error
-}
-
-func New(f Func) *Memo {
-	return &Memo{f: f, cache: make(map[string]result)}
-}
-
-func (memo *Memo) GetData(key string) (value interface{}, err error) {
-	memo.mu.Lock()
-	res, ok := memo.cache[key]
-	memo.mu.Unlock()
-	if !ok {
-		res.value, res.err = memo.f(key)
-		memo.mu.Lock()
-		memo.cache[key] = res
-		memo.mu.Unlock()
-	}
-	return res.value, res.err
-}
-
 func incomingURLs() <-chan string {
 	ch := make(chan string)
 	go func() {
@@ -0,0 +1,43 @@
+package memo
+
+import "sync"
+
+// Func 是需要被缓存结果的函数，K为键类型，V为值类型
+type Func[K comparable, V any] func(key K) (V, error)
+
+type result[V any] struct {
+	value V
+	err   error
+}
+
+// Memo 缓存Func的调用结果，并发安全
+type Memo[K comparable, V any] struct {
+	f     Func[K, V]
+	mu    sync.Mutex
+	cache map[K]result[V]
+}
+
+func New[K comparable, V any](f Func[K, V]) *Memo[K, V] {
+	return &Memo[K, V]{f: f, cache: make(map[K]result[V])}
+}
+
+// AnyFunc 是泛型之前基于interface{}的函数签名
+type AnyFunc func(key string) (interface{}, error)
+
+// NewAny 保留原来基于interface{}的接口，返回的Memo和之前一样用GetData取出interface{}
+func NewAny(f AnyFunc) *Memo[string, interface{}] {
+	return New(Func[string, interface{}](f))
+}
+
+func (memo *Memo[K, V]) GetData(key K) (V, error) {
+	memo.mu.Lock()
+	res, ok := memo.cache[key]
+	memo.mu.Unlock()
+	if !ok {
+		res.value, res.err = memo.f(key)
+		memo.mu.Lock()
+		memo.cache[key] = res
+		memo.mu.Unlock()
+	}
+	return res.value, res.err
+}
@@ -0,0 +1,187 @@
+package memo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// impls 列出Cache的所有实现，每个测试对它们逐一运行。
+// new返回的cleanup在测试结束时调用
+var impls = []struct {
+	name string
+	new  func(f Func[string, int]) (c Cache[string, int], cleanup func())
+}{
+	{"Memo", func(f Func[string, int]) (Cache[string, int], func()) {
+		return New(f), func() {}
+	}},
+	{"MonitorMemo", func(f Func[string, int]) (Cache[string, int], func()) {
+		m := NewMonitor(f)
+		return m, m.Close
+	}},
+}
+
+var errOdd = errors.New("odd")
+
+// countingFunc 把key解析成整数，奇数返回errOdd，并记录每个key被调用的次数
+func countingFunc() (Func[string, int], func(key string) int64) {
+	var mu sync.Mutex
+	calls := make(map[string]*int64)
+	f := func(key string) (int, error) {
+		mu.Lock()
+		n := calls[key]
+		if n == nil {
+			n = new(int64)
+			calls[key] = n
+		}
+		mu.Unlock()
+		atomic.AddInt64(n, 1)
+		time.Sleep(time.Millisecond)
+		v, err := strconv.Atoi(key)
+		if err != nil {
+			return 0, err
+		}
+		if v%2 != 0 {
+			return v, errOdd
+		}
+		return v, nil
+	}
+	count := func(key string) int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		if n := calls[key]; n != nil {
+			return atomic.LoadInt64(n)
+		}
+		return 0
+	}
+	return f, count
+}
+
+func TestCache(t *testing.T) {
+	for _, impl := range impls {
+		t.Run(impl.name, func(t *testing.T) {
+			f, count := countingFunc()
+			c, cleanup := impl.new(f)
+			defer cleanup()
+
+			// 重复调用只执行一次f，错误也会被缓存
+			for i := 0; i < 3; i++ {
+				for _, key := range []string{"2", "3"} {
+					v, err := c.GetData(key)
+					want, _ := strconv.Atoi(key)
+					if v != want {
+						t.Errorf("GetData(%s) = %d, want %d", key, v, want)
+					}
+					if (want%2 != 0) != (err == errOdd) {
+						t.Errorf("GetData(%s) error = %v", key, err)
+					}
+				}
+			}
+			for _, key := range []string{"2", "3"} {
+				if n := count(key); n != 1 {
+					t.Errorf("f(%s) called %d times, want 1", key, n)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	for _, impl := range impls {
+		t.Run(impl.name, func(t *testing.T) {
+			f, _ := countingFunc()
+			c, cleanup := impl.new(f)
+			defer cleanup()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 100; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					key := strconv.Itoa(2 * (i % 10))
+					if v, err := c.GetData(key); err != nil || v != 2*(i%10) {
+						t.Errorf("GetData(%s) = %d, %v", key, v, err)
+					}
+				}(i)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestMonitorClosed(t *testing.T) {
+	f, _ := countingFunc()
+	m := NewMonitor(f)
+	if _, err := m.GetData("2"); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	m.Close() // 重复Close不会panic
+	if _, err := m.GetData("2"); err != ErrClosed {
+		t.Errorf("GetData after Close error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func BenchmarkCache(b *testing.B) {
+	for _, impl := range impls {
+		b.Run(impl.name, func(b *testing.B) {
+			c, cleanup := impl.new(func(key string) (int, error) { return len(key), nil })
+			defer cleanup()
+			keys := make([]string, 100)
+			for i := range keys {
+				keys[i] = fmt.Sprint("key", i)
+			}
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					c.GetData(keys[i%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
+
+type point struct{ X, Y int }
+
+func TestStructKey(t *testing.T) {
+	var calls int64
+	f := func(p point) ([]string, error) {
+		atomic.AddInt64(&calls, 1)
+		return []string{strconv.Itoa(p.X), strconv.Itoa(p.Y)}, nil
+	}
+	m := NewMonitor(f)
+	defer m.Close()
+	for _, c := range []Cache[point, []string]{New(f), m} {
+		atomic.StoreInt64(&calls, 0)
+		for i := 0; i < 2; i++ {
+			for _, p := range []point{{1, 2}, {2, 1}} {
+				v, err := c.GetData(p)
+				if err != nil || len(v) != 2 || v[0] != strconv.Itoa(p.X) || v[1] != strconv.Itoa(p.Y) {
+					t.Errorf("%T.GetData(%v) = %v, %v", c, p, v, err)
+				}
+			}
+		}
+		// 字段相同的结构体是同一个key
+		if n := atomic.LoadInt64(&calls); n != 2 {
+			t.Errorf("%T: f called %d times, want 2", c, n)
+		}
+	}
+}
+
+func TestNewAny(t *testing.T) {
+	m := NewAny(func(key string) (interface{}, error) {
+		return len(key), nil
+	})
+	v, err := m.GetData("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := v.(int); !ok || n != 5 {
+		t.Errorf("GetData(hello) = %#v, want 5", v)
+	}
+}
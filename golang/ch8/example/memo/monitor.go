@@ -0,0 +1,86 @@
+package memo
+
+import (
+	"errors"
+	"sync"
+)
+
+// Cache 是Memo和MonitorMemo共同实现的接口
+type Cache[K comparable, V any] interface {
+	GetData(key K) (V, error)
+}
+
+var ErrClosed = errors.New("memo: closed")
+
+type entry[V any] struct {
+	res   result[V]
+	ready chan struct{} // res准备好之后关闭
+}
+
+type request[K comparable, V any] struct {
+	key      K
+	response chan<- result[V]
+}
+
+// MonitorMemo 由一个监控goroutine独占cache，客户端通过channel发送请求
+type MonitorMemo[K comparable, V any] struct {
+	requests  chan request[K, V]
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewMonitor[K comparable, V any](f Func[K, V]) *MonitorMemo[K, V] {
+	memo := &MonitorMemo[K, V]{
+		requests: make(chan request[K, V]),
+		done:     make(chan struct{}),
+	}
+	go memo.server(f)
+	return memo
+}
+
+func (memo *MonitorMemo[K, V]) GetData(key K) (V, error) {
+	response := make(chan result[V])
+	select {
+	case memo.requests <- request[K, V]{key, response}:
+	case <-memo.done:
+		var zero V
+		return zero, ErrClosed
+	}
+	res := <-response
+	return res.value, res.err
+}
+
+// Close 关闭监控goroutine，之后的GetData都会返回ErrClosed
+func (memo *MonitorMemo[K, V]) Close() {
+	memo.closeOnce.Do(func() { close(memo.done) })
+}
+
+func (memo *MonitorMemo[K, V]) server(f Func[K, V]) {
+	cache := make(map[K]*entry[V])
+	for {
+		select {
+		case req := <-memo.requests:
+			e := cache[req.key]
+			if e == nil {
+				// 第一次请求这个key，由一个goroutine去调用f
+				e = &entry[V]{ready: make(chan struct{})}
+				cache[req.key] = e
+				go call(e, f, req.key)
+			}
+			go e.deliver(req.response)
+		case <-memo.done:
+			return
+		}
+	}
+}
+
+func call[K comparable, V any](e *entry[V], f Func[K, V], key K) {
+	e.res.value, e.res.err = f(key)
+	close(e.ready)
+}
+
+func (e *entry[V]) deliver(response chan<- result[V]) {
+	// 等待结果准备好
+	<-e.ready
+	response <- e.res
+}
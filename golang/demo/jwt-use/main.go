@@ -1,52 +1,115 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"github.com/dgrijalva/jwt-go"
 	"time"
-)
 
-type MyClaims struct {
-	Username string `json:"username"`
-	jwt.StandardClaims
-}
+	"github.com/dgrijalva/jwt-go"
+	"gopl.io/demo/jwt-use/token"
+)
 
+// 第一行输出的token可以直接给websocket的客户端使用，例如：
+//
+//	go run ./demo/websocket/client -token $(go run ./demo/jwt-use -user 图图 -ttl 1h | head -1)
 func main() {
+	user := flag.String("user", "图图", "username in the token")
+	ttl := flag.Duration("ttl", time.Hour, "token lifetime")
+	key := flag.String("key", "AllYourbase", "HS256 signing key, must match the websocket server's -jwt-key")
+	flag.Parse()
+
 	// 加密的key
-	mySigningKey := []byte("AllYourbase")
+	mySigningKey := []byte(*key)
 	// token配置
-	c := MyClaims{
-		Username: "图图",
+	c := token.MyClaims{
+		Username: *user,
 		StandardClaims: jwt.StandardClaims{
 			// 生效时间
 			NotBefore: time.Now().Unix() - 60,
 			// 过期时间
-			ExpiresAt: time.Now().Unix() + 5,
+			ExpiresAt: time.Now().Add(*ttl).Unix(),
 			// 签发人
 			Issuer: "图图",
 		},
 	}
 
 	// 生成token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
 
 	// 加密token，ss就可以提供给前端了
-	ss, err := token.SignedString(mySigningKey)
+	ss, err := t.SignedString(mySigningKey)
 	if err != nil {
 		fmt.Println(err)
 	}
 	fmt.Println(ss)
 
 	// token解密
-	t, e := jwt.ParseWithClaims(ss, &MyClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return mySigningKey, nil
-	})
+	claims, e := token.Parse(ss, mySigningKey)
 
 	// token过期判断
 	if e != nil {
 		fmt.Printf("%s", e)
+		return
 	}
 
 	// 使用
-	fmt.Println(t.Claims.(*MyClaims).Username)
+	fmt.Println(claims.Username)
+
+	service(mySigningKey)
+}
+
+// service 演示TokenService，用假的时钟代替等待
+func service(key []byte) {
+	now := time.Now()
+	s := token.NewTokenService(key)
+	s.Issuer = "图图"
+	s.Now = func() time.Time { return now }
+
+	// 登录
+	pair, err := s.IssuePair("图图", time.Minute)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	claims, err := s.Verify(pair.Access)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("verify:", claims.Username)
+
+	// access token过期后用refresh token换新的
+	now = now.Add(2 * time.Minute)
+	_, err = s.Verify(pair.Access)
+	fmt.Println("verify after 2m:", err)
+	next, err := s.Refresh(pair.Refresh, time.Minute)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	_, err = s.Verify(next.Access)
+	fmt.Println("verify refreshed:", err)
+
+	// 旧的refresh token再次使用，整个family都会被吊销
+	_, err = s.Refresh(pair.Refresh, time.Minute)
+	fmt.Println("reuse old refresh:", err)
+	_, err = s.Verify(next.Access)
+	fmt.Println("verify refreshed after reuse:", err)
+	_, err = s.Refresh(next.Refresh, time.Minute)
+	fmt.Println("refresh after reuse:", err)
+
+	// 按jti吊销
+	ss, err := s.Issue("图图", time.Minute)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	claims, err = s.Verify(ss)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	s.Revoke(claims.Id)
+	_, err = s.Verify(ss)
+	fmt.Println("verify revoked:", err)
 }
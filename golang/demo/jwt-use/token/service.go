@@ -0,0 +1,217 @@
+package token
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ErrRevoked        = errors.New("token: revoked")
+	ErrRefreshInvalid = errors.New("token: invalid refresh token")
+	ErrRefreshReused  = errors.New("token: refresh token reused")
+)
+
+// Pair 是一对access token和refresh token
+type Pair struct {
+	Access  string
+	Refresh string
+}
+
+// refreshEntry 是服务端保存的refresh token，refresh token本身只是一个随机字符串
+type refreshEntry struct {
+	username  string
+	family    string // 同一次登录轮换出来的refresh token属于同一个family
+	jti       string // 一起签发的access token
+	accessExp time.Time
+	expires   time.Time
+	used      bool
+}
+
+// TokenService 签发和校验HS256的token，支持refresh token轮换和按jti吊销。
+// 吊销列表和refresh token都保存在内存里
+type TokenService struct {
+	Key        []byte
+	Issuer     string
+	RefreshTTL time.Duration    // refresh token的有效期，默认7天
+	Now        func() time.Time // 默认time.Now，测试时可以换成假的时钟
+
+	mu      sync.Mutex
+	revoked map[string]time.Time // jti -> token过期时间，零值表示一直保留
+	refresh map[string]*refreshEntry
+}
+
+func NewTokenService(key []byte) *TokenService {
+	return &TokenService{
+		Key:     key,
+		revoked: make(map[string]time.Time),
+		refresh: make(map[string]*refreshEntry),
+	}
+}
+
+func (s *TokenService) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Issue 给username签发一个ttl后过期的access token
+func (s *TokenService) Issue(username string, ttl time.Duration) (string, error) {
+	ss, _, err := s.issue(username, ttl)
+	return ss, err
+}
+
+func (s *TokenService) issue(username string, ttl time.Duration) (string, *MyClaims, error) {
+	jti, err := randomID()
+	if err != nil {
+		return "", nil, err
+	}
+	now := s.now()
+	c := &MyClaims{
+		Username: username,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Issuer:    s.Issuer,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	}
+	ss, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(s.Key)
+	if err != nil {
+		return "", nil, err
+	}
+	return ss, c, nil
+}
+
+// Verify 校验签名、有效期和吊销列表，返回token中的MyClaims
+func (s *TokenService) Verify(ss string) (*MyClaims, error) {
+	claims, err := s.parse(ss)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	_, revoked := s.revoked[claims.Id]
+	s.mu.Unlock()
+	if revoked {
+		return nil, ErrRevoked
+	}
+	return claims, nil
+}
+
+// parse 和Parse一样，但用s.now判断有效期
+func (s *TokenService) parse(ss string) (*MyClaims, error) {
+	return parseAt(ss, s.Key, s.now().Unix())
+}
+
+// IssuePair 登录时调用，签发access token和一个新family的refresh token
+func (s *TokenService) IssuePair(username string, ttl time.Duration) (*Pair, error) {
+	family, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issuePairLocked(username, family, ttl)
+}
+
+func (s *TokenService) issuePairLocked(username, family string, ttl time.Duration) (*Pair, error) {
+	access, claims, err := s.issue(username, ttl)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	refreshTTL := s.RefreshTTL
+	if refreshTTL == 0 {
+		refreshTTL = 7 * 24 * time.Hour
+	}
+	s.refresh[refresh] = &refreshEntry{
+		username:  username,
+		family:    family,
+		jti:       claims.Id,
+		accessExp: time.Unix(claims.ExpiresAt, 0),
+		expires:   s.now().Add(refreshTTL),
+	}
+	return &Pair{Access: access, Refresh: refresh}, nil
+}
+
+// Refresh 用refresh token换一对新的token，旧的refresh token随即失效。
+// 已经用过的refresh token再次出现说明它可能被盗用了，
+// 这时整个family的refresh token和它们签发的access token都会被吊销。
+// 从检查used到保存新的refresh token一直持有锁，否则重用检测可能漏掉新签发的token
+func (s *TokenService) Refresh(refresh string, ttl time.Duration) (*Pair, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.refresh[refresh]
+	if !ok || !s.now().Before(e.expires) {
+		return nil, ErrRefreshInvalid
+	}
+	if e.used {
+		s.revokeFamilyLocked(e.family)
+		return nil, ErrRefreshReused
+	}
+	e.used = true
+	return s.issuePairLocked(e.username, e.family, ttl)
+}
+
+func (s *TokenService) revokeFamilyLocked(family string) {
+	for k, e := range s.refresh {
+		if e.family == family {
+			s.revoked[e.jti] = e.accessExp
+			delete(s.refresh, k)
+		}
+	}
+}
+
+// Revoke 吊销jti对应的access token，因为不知道它的过期时间，吊销记录会一直保留
+func (s *TokenService) Revoke(jti string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[jti] = time.Time{}
+}
+
+// RevokeToken 吊销ss，token过期之后吊销记录可以被Purge清理
+func (s *TokenService) RevokeToken(ss string) error {
+	claims := new(MyClaims)
+	p := jwt.Parser{SkipClaimsValidation: true}
+	if _, err := p.ParseWithClaims(ss, claims, keyFunc(s.Key)); err != nil {
+		return convert(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[claims.Id] = time.Unix(claims.ExpiresAt, 0)
+	return nil
+}
+
+// Purge 清理已经过期的吊销记录和refresh token
+func (s *TokenService) Purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for jti, exp := range s.revoked {
+		if !exp.IsZero() && !now.Before(exp) {
+			delete(s.revoked, jti)
+		}
+	}
+	for k, e := range s.refresh {
+		if !now.Before(e.expires) {
+			delete(s.refresh, k)
+		}
+	}
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,255 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var testKey = []byte("AllYourbase")
+
+// fakeClock 是可以手动拨动的时钟
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time      { return c.now }
+func (c *fakeClock) Add(d time.Duration) { c.now = c.now.Add(d) }
+func newFakeClock() *fakeClock           { return &fakeClock{now: time.Unix(1700000000, 0)} }
+func newTestService(c *fakeClock) *TokenService {
+	s := NewTokenService(testKey)
+	s.Now = c.Now
+	return s
+}
+
+// sign 用method和key签发claims
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+	ss, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ss
+}
+
+func TestVerify(t *testing.T) {
+	clock := newFakeClock()
+	claims := func() *MyClaims {
+		now := clock.Now()
+		return &MyClaims{
+			Username: "图图",
+			StandardClaims: jwt.StandardClaims{
+				Id:        "jti",
+				NotBefore: now.Unix(),
+				ExpiresAt: now.Add(time.Minute).Unix(),
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		token   func(s *TokenService) string
+		advance time.Duration
+		want    error
+	}{
+		{"valid", func(s *TokenService) string {
+			ss, _ := s.Issue("图图", time.Minute)
+			return ss
+		}, 0, nil},
+		{"expired", func(s *TokenService) string {
+			ss, _ := s.Issue("图图", time.Minute)
+			return ss
+		}, time.Minute + time.Second, ErrExpired},
+		{"not valid yet", func(s *TokenService) string {
+			ss, _ := s.Issue("图图", time.Minute)
+			return ss
+		}, -time.Second, ErrNotValidYet},
+		{"bad signature", func(s *TokenService) string {
+			return sign(t, jwt.SigningMethodHS256, []byte("wrong key"), claims())
+		}, 0, ErrBadSignature},
+		{"alg none", func(s *TokenService) string {
+			return sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims())
+		}, 0, ErrBadSignature},
+		{"alg HS512", func(s *TokenService) string {
+			return sign(t, jwt.SigningMethodHS512, testKey, claims())
+		}, 0, ErrBadSignature},
+		{"no exp", func(s *TokenService) string {
+			c := claims()
+			c.ExpiresAt = 0
+			return sign(t, jwt.SigningMethodHS256, testKey, c)
+		}, 0, ErrExpired},
+		{"malformed", func(s *TokenService) string {
+			return "not.a.token"
+		}, 0, ErrMalformed},
+		{"revoked", func(s *TokenService) string {
+			ss, _ := s.Issue("图图", time.Minute)
+			c, err := s.Verify(ss)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Revoke(c.Id)
+			return ss
+		}, 0, ErrRevoked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock = newFakeClock()
+			s := newTestService(clock)
+			ss := tt.token(s)
+			clock.Add(tt.advance)
+			c, err := s.Verify(ss)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.want)
+			}
+			if err == nil && c.Username != "图图" {
+				t.Errorf("Username = %q, want %q", c.Username, "图图")
+			}
+		})
+	}
+}
+
+func TestParseRequiresExp(t *testing.T) {
+	ss := sign(t, jwt.SigningMethodHS256, testKey, &MyClaims{Username: "eve"})
+	if _, err := Parse(ss, testKey); err != ErrExpired {
+		t.Errorf("Parse(no exp) error = %v, want %v", err, ErrExpired)
+	}
+	ss = sign(t, jwt.SigningMethodHS256, testKey, &MyClaims{
+		Username:       "eve",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()},
+	})
+	if _, err := Parse(ss, testKey); err != nil {
+		t.Errorf("Parse(valid) error = %v", err)
+	}
+}
+
+func TestRefreshRotation(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestService(clock)
+
+	first, err := s.IssuePair("图图", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Add(2 * time.Minute)
+	if _, err := s.Verify(first.Access); err != ErrExpired {
+		t.Fatalf("Verify(first) error = %v, want %v", err, ErrExpired)
+	}
+
+	second, err := s.Refresh(first.Refresh, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Refresh == first.Refresh {
+		t.Fatal("Refresh returned the same refresh token")
+	}
+	c, err := s.Verify(second.Access)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Username != "图图" {
+		t.Errorf("Username = %q, want %q", c.Username, "图图")
+	}
+
+	// 新的refresh token可以继续轮换
+	third, err := s.Refresh(second.Refresh, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(third.Access); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestService(clock)
+
+	first, err := s.IssuePair("图图", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.IssuePair("小美", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Refresh(first.Refresh, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Refresh(first.Refresh, time.Minute); err != ErrRefreshReused {
+		t.Fatalf("reuse error = %v, want %v", err, ErrRefreshReused)
+	}
+	for _, ss := range []string{first.Access, second.Access} {
+		if _, err := s.Verify(ss); err != ErrRevoked {
+			t.Errorf("Verify() error = %v, want %v", err, ErrRevoked)
+		}
+	}
+	if _, err := s.Refresh(second.Refresh, time.Minute); err != ErrRefreshInvalid {
+		t.Errorf("Refresh(second) error = %v, want %v", err, ErrRefreshInvalid)
+	}
+
+	// 其他family不受影响
+	if _, err := s.Verify(other.Access); err != nil {
+		t.Errorf("Verify(other) error = %v", err)
+	}
+	if _, err := s.Refresh(other.Refresh, time.Minute); err != nil {
+		t.Errorf("Refresh(other) error = %v", err)
+	}
+}
+
+func TestRefreshExpired(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestService(clock)
+	s.RefreshTTL = time.Hour
+
+	pair, err := s.IssuePair("图图", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Add(time.Hour)
+	if _, err := s.Refresh(pair.Refresh, time.Minute); err != ErrRefreshInvalid {
+		t.Fatalf("Refresh() error = %v, want %v", err, ErrRefreshInvalid)
+	}
+	if _, err := s.Refresh("unknown", time.Minute); err != ErrRefreshInvalid {
+		t.Fatalf("Refresh(unknown) error = %v, want %v", err, ErrRefreshInvalid)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	clock := newFakeClock()
+	s := newTestService(clock)
+	s.RefreshTTL = 2 * time.Minute
+
+	ss, err := s.Issue("图图", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeToken(ss); err != nil {
+		t.Fatal(err)
+	}
+	s.Revoke("forever")
+	if _, err := s.IssuePair("图图", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// 都还没过期，Purge不清理
+	s.Purge()
+	if len(s.revoked) != 2 || len(s.refresh) != 1 {
+		t.Fatalf("after Purge: %d revoked, %d refresh; want 2, 1", len(s.revoked), len(s.refresh))
+	}
+	if _, err := s.Verify(ss); err != ErrRevoked {
+		t.Fatalf("Verify() error = %v, want %v", err, ErrRevoked)
+	}
+
+	clock.Add(2 * time.Minute)
+	s.Purge()
+	if _, ok := s.revoked["forever"]; !ok || len(s.revoked) != 1 {
+		t.Errorf("revoked = %v, want only forever", s.revoked)
+	}
+	if len(s.refresh) != 0 {
+		t.Errorf("%d refresh tokens left, want 0", len(s.refresh))
+	}
+}
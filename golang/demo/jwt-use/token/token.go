@@ -0,0 +1,71 @@
+package token
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+type MyClaims struct {
+	Username string `json:"username"`
+	jwt.StandardClaims
+}
+
+var (
+	ErrMalformed    = errors.New("token: malformed")
+	ErrBadSignature = errors.New("token: bad signature")
+	ErrExpired      = errors.New("token: expired")
+	ErrNotValidYet  = errors.New("token: not valid yet")
+)
+
+// Parse 用key校验HS256签名和有效期，返回token中的MyClaims。没有exp的token也会被拒绝
+func Parse(s string, key []byte) (*MyClaims, error) {
+	return parseAt(s, key, time.Now().Unix())
+}
+
+// parseAt 以now为当前时间校验token。jwt-go默认把缺少exp的token当作永不过期，
+// 所以跳过它自带的校验，自己要求exp必须存在
+func parseAt(s string, key []byte, now int64) (*MyClaims, error) {
+	claims := new(MyClaims)
+	p := jwt.Parser{SkipClaimsValidation: true}
+	if _, err := p.ParseWithClaims(s, claims, keyFunc(key)); err != nil {
+		return nil, convert(err)
+	}
+	if !claims.VerifyExpiresAt(now, true) {
+		return nil, ErrExpired
+	}
+	if !claims.VerifyNotBefore(now, false) {
+		return nil, ErrNotValidYet
+	}
+	return claims, nil
+}
+
+func keyFunc(key []byte) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		// 只接受HS256，防止把算法改成none或其他算法绕过校验
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, ErrBadSignature
+		}
+		return key, nil
+	}
+}
+
+// convert 把jwt-go的ValidationError转换成上面定义的错误
+func convert(err error) error {
+	var ve *jwt.ValidationError
+	if !errors.As(err, &ve) {
+		return err
+	}
+	switch {
+	case ve.Errors&jwt.ValidationErrorMalformed != 0:
+		return ErrMalformed
+	case ve.Errors&(jwt.ValidationErrorSignatureInvalid|jwt.ValidationErrorUnverifiable) != 0:
+		return ErrBadSignature
+	case ve.Errors&jwt.ValidationErrorExpired != 0:
+		return ErrExpired
+	case ve.Errors&jwt.ValidationErrorNotValidYet != 0:
+		return ErrNotValidYet
+	}
+	return err
+}
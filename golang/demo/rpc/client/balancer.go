@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopl.io/demo/rpc/discovery"
+)
+
+var ErrNoInstance = errors.New("no live instance")
+
+// Policy 决定从哪个实例发起调用
+type Policy int
+
+const (
+	RoundRobin    Policy = iota // 轮流使用每个实例
+	LeastInflight               // 使用正在执行的调用最少的实例
+)
+
+func (p Policy) String() string {
+	switch p {
+	case RoundRobin:
+		return "round-robin"
+	case LeastInflight:
+		return "least-inflight"
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// Set 实现flag.Value
+func (p *Policy) Set(s string) error {
+	switch s {
+	case "round-robin":
+		*p = RoundRobin
+	case "least-inflight":
+		*p = LeastInflight
+	default:
+		return fmt.Errorf("unknown policy %q", s)
+	}
+	return nil
+}
+
+type backend struct {
+	addr     string
+	client   *rpc.Client // nil表示还没有连接或者连接已经断开
+	removed  bool        // 已经不在registry中
+	inflight int64
+	calls    int64
+}
+
+// Balancer 从registry解析服务的实例，在存活的实例之间做负载均衡
+type Balancer struct {
+	Registry string
+	Service  string
+	Policy   Policy
+	Refresh  time.Duration // 多久重新查询一次registry，默认5秒
+
+	// Idempotent 返回true的方法因为连接问题失败时，会换一个实例重试。
+	// 还没发出去的调用(连接失败)总是会重试
+	Idempotent func(method string) bool
+
+	// Dial 连接一个实例，默认是不带secret的dialHTTP。
+	// 必须在ctx结束时返回，否则一个只接受连接不响应的实例会让Call超过截止时间
+	Dial func(ctx context.Context, addr string) (*rpc.Client, error)
+
+	mu       sync.Mutex
+	backends []*backend
+	next     int
+	resolved time.Time
+}
+
+// Call 选择一个实例调用method
+func (b *Balancer) Call(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	tried := make(map[string]bool)
+	var lastErr error
+	for {
+		be, client, err := b.pick(ctx, tried)
+		if err != nil {
+			if lastErr != nil {
+				return lastErr
+			}
+			return err
+		}
+		if client == nil {
+			// 连接失败，调用还没有发出去，换一个实例
+			tried[be.addr] = true
+			continue
+		}
+
+		atomic.AddInt64(&be.inflight, 1)
+		err = (&Client{client}).CallContext(ctx, method, args, reply)
+		atomic.AddInt64(&be.inflight, -1)
+		if err == nil || !retryable(ctx, err) {
+			atomic.AddInt64(&be.calls, 1)
+			return err
+		}
+
+		b.evict(be, client)
+		lastErr = err
+		if b.Idempotent == nil || !b.Idempotent(method) {
+			return err
+		}
+		tried[be.addr] = true
+		log.Printf("event=retry method=%s addr=%s err=%v", method, be.addr, err)
+	}
+}
+
+// Stats 返回每个实例完成的调用数
+func (b *Balancer) Stats() map[string]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := make(map[string]int64)
+	for _, be := range b.backends {
+		stats[be.addr] = atomic.LoadInt64(&be.calls)
+	}
+	return stats
+}
+
+// Close 关闭所有连接
+func (b *Balancer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, be := range b.backends {
+		if be.client != nil {
+			be.client.Close()
+			be.client = nil
+		}
+	}
+}
+
+// retryable 报告err是不是连接问题，服务端返回的错误和ctx的错误不重试
+func retryable(ctx context.Context, err error) bool {
+	var serverErr rpc.ServerError
+	return !errors.As(err, &serverErr) && ctx.Err() == nil
+}
+
+// pick 按Policy选择一个没有试过的实例，返回的client为nil表示连接失败
+func (b *Balancer) pick(ctx context.Context, tried map[string]bool) (*backend, *rpc.Client, error) {
+	if err := b.resolve(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	b.mu.Lock()
+	var live []*backend
+	for _, be := range b.backends {
+		if !tried[be.addr] {
+			live = append(live, be)
+		}
+	}
+	if len(live) == 0 {
+		b.mu.Unlock()
+		return nil, nil, ErrNoInstance
+	}
+
+	var be *backend
+	switch b.Policy {
+	case LeastInflight:
+		be = live[0]
+		for _, x := range live[1:] {
+			if atomic.LoadInt64(&x.inflight) < atomic.LoadInt64(&be.inflight) {
+				be = x
+			}
+		}
+	default:
+		be = live[b.next%len(live)]
+		b.next++
+	}
+	client := be.client
+	b.mu.Unlock()
+	if client != nil {
+		return be, client, nil
+	}
+
+	// 在锁外连接，一个连不上的实例不会阻塞其他调用
+	dial := b.Dial
+	if dial == nil {
+		dial = func(ctx context.Context, addr string) (*rpc.Client, error) { return dialHTTP(ctx, addr, "") }
+	}
+	client, err := dial(ctx, be.addr)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		log.Printf("event=dial_failed addr=%s err=%v", be.addr, err)
+		b.resolved = time.Time{} // 下次重新查询registry
+		return be, nil, nil
+	}
+	switch {
+	case be.removed:
+		// 连接期间实例已经从registry中消失
+		client.Close()
+		return be, nil, nil
+	case be.client != nil:
+		// 其他调用已经连上了
+		client.Close()
+	default:
+		be.client = client
+	}
+	return be, be.client, nil
+}
+
+// resolve 超过Refresh时重新查询registry，保留仍然存活的实例的连接
+func (b *Balancer) resolve(ctx context.Context) error {
+	refresh := b.Refresh
+	if refresh == 0 {
+		refresh = 5 * time.Second
+	}
+	b.mu.Lock()
+	fresh := len(b.backends) > 0 && time.Since(b.resolved) < refresh
+	b.mu.Unlock()
+	if fresh {
+		return nil
+	}
+
+	list, err := discovery.Lookup(ctx, b.Registry, b.Service)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		if len(b.backends) > 0 {
+			// registry不可用时继续使用已知的实例
+			log.Printf("event=lookup_failed service=%s err=%v", b.Service, err)
+			return nil
+		}
+		return err
+	}
+
+	old := make(map[string]*backend)
+	for _, be := range b.backends {
+		old[be.addr] = be
+	}
+	b.backends = b.backends[:0]
+	for _, inst := range list {
+		be, ok := old[inst.Addr]
+		if !ok {
+			be = &backend{addr: inst.Addr}
+		}
+		delete(old, inst.Addr)
+		b.backends = append(b.backends, be)
+	}
+	for _, be := range old {
+		be.removed = true
+		if be.client != nil {
+			be.client.Close()
+		}
+	}
+	b.resolved = time.Now()
+	return nil
+}
+
+// evict 关闭出错的连接，下次选中时重新连接
+func (b *Balancer) evict(be *backend, client *rpc.Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if be.client == client {
+		be.client = nil
+		b.resolved = time.Time{}
+	}
+	client.Close()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopl.io/demo/rpc/discovery"
+)
+
+// TestDialStuckInstance 检查一个接受连接但不响应CONNECT的实例不会让Call超过ctx的截止时间
+func TestDialStuckInstance(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close() // 不读也不写，直到测试结束
+		}
+	}()
+
+	registry := discovery.NewRegistry(time.Minute)
+	registry.Register(discovery.Instance{Service: "rpc", Addr: l.Addr().String()})
+	rs := httptest.NewServer(registry)
+	defer rs.Close()
+
+	b := &Balancer{Registry: rs.URL, Service: "rpc"}
+	defer b.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var res Res
+	if err := b.Call(ctx, "Server.Add", &Req{NumOne: 1, NumTwo: 2}, &res); err == nil {
+		t.Fatal("Call to a stuck instance succeeded")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Call returned after %v, want about 100ms", d)
+	}
+}
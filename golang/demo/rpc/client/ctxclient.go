@@ -0,0 +1,18 @@
+package main
+
+import (
+	"context"
+	"net/rpc"
+
+	"gopl.io/demo/rpc/rpcctx"
+)
+
+// Client 在rpc.Client的基础上支持context
+type Client struct {
+	*rpc.Client
+}
+
+// CallContext 见rpcctx.Call
+func (c *Client) CallContext(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	return rpcctx.Call(ctx, c.Client, method, args, reply)
+}
@@ -1,42 +1,255 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"net/rpc"
+	"net/rpc/jsonrpc"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
+
+	"gopl.io/demo/rpc/rpcctx"
 )
 
 type Req struct {
 	NumOne, NumTwo int
+	rpcctx.CallCtx
 }
 
 type Res struct {
 	Num int
 }
 
+type Op struct {
+	Op string
+	Req
+}
+
+type EvalReq struct {
+	Ops []Op
+}
+
+type Result struct {
+	Num   int
+	Error string
+}
+
+type EvalRes struct {
+	Results []Result
+}
+
 func main() {
-	req := Req{NumOne: 10, NumTwo: 20}
-	var res Res
-	client, err := rpc.DialHTTP("tcp", "localhost:8888")
+	codec := flag.String("codec", "gob", "gob (net/rpc over HTTP on :8888) or json (JSON-RPC over TCP on :8889)")
+	timeout := flag.Duration("timeout", 2*time.Second, "timeout of Server.Add, which takes 5s")
+	registry := flag.String("registry", "", "registry URL, e.g. http://localhost:8890 (empty: dial the server directly)")
+	service := flag.String("service", "rpc", "service name to resolve from the registry")
+	n := flag.Int("n", 20, "number of calls to spread across instances")
+	secret := flag.String("secret", "", "shared secret sent as Authorization: Bearer <secret>")
+	stream := flag.Bool("stream", false, "call Stream.Add and print its progress")
+	streamTimeout := flag.Duration("stream-timeout", 10*time.Second, "timeout of the whole -stream call; Stream.Add takes 5s")
+	var policy Policy
+	flag.Var(&policy, "policy", "round-robin or least-inflight")
+	flag.Parse()
+
+	if *registry != "" {
+		b := &Balancer{
+			Registry:   *registry,
+			Service:    *service,
+			Policy:     policy,
+			Idempotent: idempotent,
+			Dial: func(ctx context.Context, addr string) (*rpc.Client, error) {
+				return dialHTTP(ctx, addr, *secret)
+			},
+		}
+		defer b.Close()
+		balance(b, *n)
+		return
+	}
+
+	client, err := dial(*codec, *secret)
 	if err != nil {
 		log.Fatal("dialing", err)
 	}
-	ca := client.Go("Server.Add", req, &res, nil)
+	defer client.Close()
 
-	for {
-		select {
-		case <-ca.Done:
-			fmt.Println("收到远程方法的返回", res)
-			return
-		default:
-			time.Sleep(1 * time.Second)
-			fmt.Println("在等待时做一些操作")
-		}
+	c := &Client{client}
+
+	if *stream {
+		progress(c, *streamTimeout)
+		return
 	}
 
-	// <-ca.Done
+	// Server.Add需要5秒，超时后服务端也会停止执行
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	req := Req{NumOne: 10, NumTwo: 20}
+	var res Res
+	if err := c.CallContext(ctx, "Server.Add", &req, &res); err != nil {
+		fmt.Println("Server.Add 出错了:", err)
+	} else {
+		fmt.Println("收到远程方法的返回", res)
+	}
 
 	// 同步方法 client.Call("Server.Add", req, &res)
 	// fmt.Println("远程方法返回的东西", res)
+
+	calculate(client)
+}
+
+func dial(codec, secret string) (*rpc.Client, error) {
+	switch codec {
+	case "gob":
+		return dialHTTP(context.Background(), "localhost:8888", secret)
+	case "json":
+		return dialJSON("localhost:8889", secret)
+	}
+	return nil, fmt.Errorf("unknown codec %q", codec)
+}
+
+// handshakeTimeout 是ctx没有截止时间时CONNECT握手的超时
+const handshakeTimeout = 10 * time.Second
+
+// dialHTTP 和rpc.DialHTTP一样，但是在CONNECT请求里带上Authorization。
+// 连接和CONNECT握手都不会超过ctx的截止时间
+func dialHTTP(ctx context.Context, addr, secret string) (*rpc.Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(handshakeTimeout)
+	}
+	conn.SetDeadline(deadline)
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Path: rpc.DefaultRPCPath},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.Status != "200 Connected to Go RPC" {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected HTTP response: %s", resp.Status)
+	}
+	// 握手完成后由CallContext控制每个调用的超时
+	conn.SetDeadline(time.Time{})
+	return rpc.NewClient(conn), nil
+}
+
+// dialJSON 连接JSON-RPC服务，有secret时先发送header
+func dialJSON(addr, secret string) (*rpc.Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if secret != "" {
+		if _, err := fmt.Fprintf(conn, "Authorization: Bearer %s\r\n\r\n", secret); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return jsonrpc.NewClient(conn), nil
+}
+
+// calculate 调用Calculator服务
+func calculate(client *rpc.Client) {
+	for _, method := range []string{"Sub", "Mul", "Div"} {
+		var res Res
+		req := Req{NumOne: 10, NumTwo: 0}
+		if err := client.Call("Calculator."+method, req, &res); err != nil {
+			// 服务端返回的错误是rpc.ServerError
+			fmt.Println(method, "出错了:", err)
+			continue
+		}
+		fmt.Println(method, res.Num)
+	}
+
+	var eval EvalRes
+	ops := []Op{
+		{"Add", Req{NumOne: 1, NumTwo: 2}},
+		{"Mul", Req{NumOne: 3, NumTwo: 4}},
+		{"Div", Req{NumOne: 5, NumTwo: 0}},
+		{"Pow", Req{NumOne: 2, NumTwo: 3}},
+	}
+	if err := client.Call("Calculator.Eval", EvalReq{Ops: ops}, &eval); err != nil {
+		fmt.Println("Eval 出错了:", err)
+		return
+	}
+	for i, r := range eval.Results {
+		if r.Error != "" {
+			fmt.Println(ops[i].Op, ops[i].NumOne, ops[i].NumTwo, "出错了:", r.Error)
+			continue
+		}
+		fmt.Println(ops[i].Op, ops[i].NumOne, ops[i].NumTwo, r.Num)
+	}
+}
+
+// progress 调用Stream.Add，打印进度和最终结果
+func progress(c *Client, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	s, err := c.Stream(ctx, "Stream.Add", &Req{NumOne: 10, NumTwo: 20})
+	if err != nil {
+		fmt.Println("Stream.Add 出错了:", err)
+		return
+	}
+	for e := range s.C {
+		if e.Final {
+			fmt.Println("结果", e.Num)
+			continue
+		}
+		fmt.Printf("进度 %d%%\n", e.Progress)
+	}
+	if err := s.Err(); err != nil {
+		fmt.Println("Stream.Add 出错了:", err)
+	}
+}
+
+// idempotent 报告method能不能安全地重试，这些方法都只做计算
+func idempotent(method string) bool {
+	return strings.HasPrefix(method, "Calculator.") || method == "Server.Add"
+}
+
+// balance 并发调用n次Calculator.Add，打印每个实例处理的调用数
+func balance(b *Balancer, n int) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var res Res
+			if err := b.Call(ctx, "Calculator.Add", &Req{NumOne: i, NumTwo: i}, &res); err != nil {
+				fmt.Println("Calculator.Add 出错了:", err)
+				return
+			}
+			fmt.Println(i, "+", i, "=", res.Num)
+		}(i)
+	}
+	wg.Wait()
+
+	for addr, calls := range b.Stats() {
+		fmt.Println(addr, calls)
+	}
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+)
+
+// Event 和服务端的定义一致
+type Event struct {
+	Progress int
+	Final    bool
+	Num      int
+}
+
+type NextRes struct {
+	Events []Event
+	EOF    bool
+	Error  string
+}
+
+// Stream 是一个流式调用，从C读取部分结果，C关闭后Err返回流结束的原因
+type Stream struct {
+	C   <-chan Event
+	err error
+}
+
+// Err 在C关闭后调用，流正常结束时返回nil
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Stream 调用method启动一个流式调用，然后在后台不断地调用Stream.Next。
+// ctx结束时流会被取消
+func (c *Client) Stream(ctx context.Context, method string, args interface{}) (*Stream, error) {
+	var id uint64
+	if err := c.CallContext(ctx, method, args, &id); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Event)
+	s := &Stream{C: ch}
+	go func() {
+		defer close(ch)
+		s.err = c.poll(ctx, id, ch)
+		if s.err != nil {
+			// 不等待结果，流可能已经在服务端结束了
+			c.Go("Stream.Close", id, new(struct{}), make(chan *rpc.Call, 1))
+		}
+	}()
+	return s, nil
+}
+
+func (c *Client) poll(ctx context.Context, id uint64, ch chan<- Event) error {
+	for {
+		var res NextRes
+		if err := c.CallContext(ctx, "Stream.Next", id, &res); err != nil {
+			return err
+		}
+		for _, e := range res.Events {
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if res.EOF {
+			if res.Error != "" {
+				return errors.New(res.Error)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package discovery 是一个很简单的服务注册中心。
+//
+// 服务端定期调用Register(心跳)，超过TTL没有心跳的实例会被移除；
+// 客户端用Lookup查询某个服务当前存活的实例。
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Instance 是服务的一个实例
+type Instance struct {
+	Service string
+	Addr    string   // net/rpc over HTTP的地址
+	Methods []string // 例如 Calculator.Add
+	Expires time.Time
+}
+
+// Registry 是内存中的注册表，实现了http.Handler：
+//
+//	POST   /register            注册或续期，body是Instance
+//	DELETE /register?service=&addr=  注销
+//	GET    /services/<service>  查询存活的实例
+type Registry struct {
+	TTL time.Duration
+
+	mu        sync.Mutex
+	instances map[string]map[string]Instance // service -> addr -> instance
+}
+
+func NewRegistry(ttl time.Duration) *Registry {
+	return &Registry{TTL: ttl, instances: make(map[string]map[string]Instance)}
+}
+
+// Register 注册或续期一个实例，返回过期时间
+func (r *Registry) Register(inst Instance) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	inst.Expires = time.Now().Add(r.TTL)
+	m := r.instances[inst.Service]
+	if m == nil {
+		m = make(map[string]Instance)
+		r.instances[inst.Service] = m
+	}
+	m[inst.Addr] = inst
+	return inst.Expires
+}
+
+func (r *Registry) Deregister(service, addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.instances[service], addr)
+}
+
+// Lookup 返回service所有存活的实例，按地址排序
+func (r *Registry) Lookup(service string) []Instance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var list []Instance
+	for _, inst := range r.instances[service] {
+		if now.Before(inst.Expires) {
+			list = append(list, inst)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Addr < list[j].Addr })
+	return list
+}
+
+// Expire 移除过期的实例，返回被移除的实例
+func (r *Registry) Expire() []Instance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var expired []Instance
+	for service, m := range r.instances {
+		for addr, inst := range m {
+			if !now.Before(inst.Expires) {
+				expired = append(expired, inst)
+				delete(m, addr)
+			}
+		}
+		if len(m) == 0 {
+			delete(r.instances, service)
+		}
+	}
+	return expired
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/register" && req.Method == http.MethodPost:
+		var inst Instance
+		if err := json.NewDecoder(req.Body).Decode(&inst); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if inst.Service == "" || inst.Addr == "" {
+			http.Error(w, "service and addr are required", http.StatusBadRequest)
+			return
+		}
+		inst.Expires = r.Register(inst)
+		json.NewEncoder(w).Encode(inst)
+	case req.URL.Path == "/register" && req.Method == http.MethodDelete:
+		q := req.URL.Query()
+		r.Deregister(q.Get("service"), q.Get("addr"))
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(req.URL.Path, "/services/") && req.Method == http.MethodGet:
+		service := strings.TrimPrefix(req.URL.Path, "/services/")
+		list := r.Lookup(service)
+		if list == nil {
+			list = []Instance{}
+		}
+		json.NewEncoder(w).Encode(list)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// Registration 是Register返回的注册，Close之前会定期续期
+type Registration struct {
+	registry string
+	inst     Instance
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// Register 向registry注册inst，之后每隔interval续期一次，直到Close。
+// 第一次注册失败直接返回错误，之后的续期失败只会交给onError
+func Register(registry string, inst Instance, interval time.Duration, onError func(error)) (*Registration, error) {
+	if err := heartbeat(registry, inst); err != nil {
+		return nil, err
+	}
+	r := &Registration{registry: registry, inst: inst, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := heartbeat(registry, inst); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+	return r, nil
+}
+
+// Close 停止续期并从registry注销
+func (r *Registration) Close() error {
+	close(r.stop)
+	<-r.done
+	q := url.Values{"service": {r.inst.Service}, "addr": {r.inst.Addr}}
+	req, err := http.NewRequest(http.MethodDelete, r.registry+"/register?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	return do(req, nil)
+}
+
+func heartbeat(registry string, inst Instance) error {
+	body, err := json.Marshal(inst)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, registry+"/register", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return do(req, nil)
+}
+
+// Lookup 向registry查询service存活的实例
+func Lookup(ctx context.Context, registry, service string) ([]Instance, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, registry+"/services/"+url.PathEscape(service), nil)
+	if err != nil {
+		return nil, err
+	}
+	var list []Instance
+	if err := do(req, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+var httpClient = &http.Client{Timeout: 5 * time.Second}
+
+func do(req *http.Request, v interface{}) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
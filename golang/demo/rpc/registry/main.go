@@ -0,0 +1,29 @@
+// registry 是demo/rpc的服务注册中心
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"gopl.io/demo/rpc/discovery"
+)
+
+func main() {
+	addr := flag.String("addr", ":8890", "listen address")
+	ttl := flag.Duration("ttl", 10*time.Second, "instances without a heartbeat for this long are removed")
+	flag.Parse()
+
+	reg := discovery.NewRegistry(*ttl)
+	go func() {
+		for range time.Tick(*ttl / 2) {
+			for _, inst := range reg.Expire() {
+				log.Printf("event=expire service=%s addr=%s", inst.Service, inst.Addr)
+			}
+		}
+	}()
+
+	log.Printf("event=listen addr=%s ttl=%s", *addr, *ttl)
+	log.Fatal(http.ListenAndServe(*addr, reg))
+}
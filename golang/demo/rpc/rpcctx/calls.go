@@ -0,0 +1,76 @@
+package rpcctx
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// canceledTTL 是提前到达的Cancel保留的时间。
+// 调用结束之后才到达的Cancel永远等不到对应的调用，过期后被清理
+const canceledTTL = time.Minute
+
+// Calls 在服务端记录正在执行的可取消调用，CancelMethod的实现调用它的Cancel。
+// Cancel可能比调用本身先到达服务端，这时先记在canceled里，Begin时直接返回已取消的ctx
+type Calls struct {
+	mu        sync.Mutex
+	running   map[uint64]context.CancelFunc
+	canceled  map[uint64]time.Time // CallID -> Cancel到达的时间
+	lastPrune time.Time
+}
+
+func NewCalls() *Calls {
+	return &Calls{
+		running:  make(map[uint64]context.CancelFunc),
+		canceled: make(map[uint64]time.Time),
+	}
+}
+
+// Begin 返回这个调用的ctx，调用结束后必须调用done
+func (cs *Calls) Begin(cc CallCtx) (ctx context.Context, done func()) {
+	ctx, cancel := Context(cc)
+	if cc.CallID == 0 {
+		return ctx, cancel
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if _, ok := cs.canceled[cc.CallID]; ok {
+		delete(cs.canceled, cc.CallID)
+		cancel()
+		return ctx, cancel
+	}
+	cs.running[cc.CallID] = cancel
+	return ctx, func() {
+		cs.mu.Lock()
+		delete(cs.running, cc.CallID)
+		cs.mu.Unlock()
+		cancel()
+	}
+}
+
+// Cancel 取消CallID为id的调用
+func (cs *Calls) Cancel(id uint64) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cancel, ok := cs.running[id]; ok {
+		cancel()
+		return
+	}
+	now := time.Now()
+	cs.canceled[id] = now
+	cs.pruneLocked(now)
+}
+
+// pruneLocked 清理过期的canceled，最多每canceledTTL/2执行一次
+func (cs *Calls) pruneLocked(now time.Time) {
+	if now.Sub(cs.lastPrune) < canceledTTL/2 {
+		return
+	}
+	cs.lastPrune = now
+	for id, t := range cs.canceled {
+		if now.Sub(t) > canceledTTL {
+			delete(cs.canceled, id)
+		}
+	}
+}
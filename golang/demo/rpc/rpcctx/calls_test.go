@@ -0,0 +1,56 @@
+package rpcctx
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCancelRunning(t *testing.T) {
+	cs := NewCalls()
+	ctx, done := cs.Begin(CallCtx{CallID: 1})
+	defer done()
+	cs.Cancel(1)
+	if ctx.Err() != context.Canceled {
+		t.Fatalf("ctx.Err() = %v, want %v", ctx.Err(), context.Canceled)
+	}
+}
+
+func TestCancelBeforeBegin(t *testing.T) {
+	cs := NewCalls()
+	cs.Cancel(1)
+	ctx, done := cs.Begin(CallCtx{CallID: 1})
+	defer done()
+	if ctx.Err() != context.Canceled {
+		t.Fatalf("ctx.Err() = %v, want %v", ctx.Err(), context.Canceled)
+	}
+	if len(cs.canceled) != 0 {
+		t.Errorf("canceled = %v, want empty", cs.canceled)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	cs := NewCalls()
+	ctx, done := cs.Begin(CallCtx{CallID: 1, Deadline: time.Now().Add(-time.Second).UnixNano()})
+	defer done()
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Fatalf("ctx.Err() = %v, want %v", ctx.Err(), context.DeadlineExceeded)
+	}
+}
+
+func TestPruneCanceled(t *testing.T) {
+	cs := NewCalls()
+	cs.Cancel(1) // 调用已经结束，永远不会Begin
+	cs.mu.Lock()
+	cs.canceled[1] = time.Now().Add(-2 * canceledTTL)
+	cs.lastPrune = time.Time{}
+	cs.mu.Unlock()
+
+	cs.Cancel(2)
+	if _, ok := cs.canceled[1]; ok {
+		t.Error("expired Cancel was not pruned")
+	}
+	if _, ok := cs.canceled[2]; !ok {
+		t.Error("recent Cancel was pruned")
+	}
+}
@@ -0,0 +1,66 @@
+// Package rpcctx 让net/rpc的调用支持context。
+//
+// 请求参数嵌入CallCtx后，客户端的截止时间会随请求发送到服务端，
+// 客户端取消时会调用CancelMethod通知服务端停止执行。
+package rpcctx
+
+import (
+	"context"
+	"math/rand"
+	"net/rpc"
+	"time"
+)
+
+// CancelMethod 是服务端用来取消调用的方法，参数是CallID
+var CancelMethod = "Server.Cancel"
+
+// CallCtx 嵌入在请求参数中，让服务端能感知客户端的取消和截止时间。
+// CallID为0表示这个调用不能取消
+type CallCtx struct {
+	CallID   uint64
+	Deadline int64 // Unix纳秒，0表示没有截止时间
+}
+
+func (c *CallCtx) callCtx() *CallCtx { return c }
+
+// cancelable 由嵌入了CallCtx的请求参数实现
+type cancelable interface {
+	callCtx() *CallCtx
+}
+
+// Call 和client.Call一样，但ctx结束时立即返回ctx.Err()。
+// 如果args是嵌入了CallCtx的指针，会带上截止时间，并在取消时通知服务端停止执行
+func Call(ctx context.Context, client *rpc.Client, method string, args interface{}, reply interface{}) error {
+	var id uint64
+	if cc, ok := args.(cancelable); ok {
+		id = rand.Uint64() | 1 // 0表示不可取消
+		cc.callCtx().CallID = id
+		if deadline, ok := ctx.Deadline(); ok {
+			cc.callCtx().Deadline = deadline.UnixNano()
+		}
+	}
+
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		if id != 0 {
+			// 不等待结果，服务端可能已经执行完了
+			client.Go(CancelMethod, id, new(struct{}), make(chan *rpc.Call, 1))
+		}
+		return ctx.Err()
+	}
+}
+
+// Context 返回cc对应的ctx，有截止时间时带上截止时间
+func Context(cc CallCtx) (context.Context, context.CancelFunc) {
+	if cc.Deadline != 0 {
+		return context.WithDeadline(context.Background(), time.Unix(0, cc.Deadline))
+	}
+	return context.WithCancel(context.Background())
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
@@ -0,0 +1,80 @@
+package main
+
+import "errors"
+
+var ErrDivideByZero = errors.New("divide by zero")
+
+// Calculator 提供四则运算，参数和返回值沿用Server.Add的Req和Res
+type Calculator struct {
+}
+
+func (c *Calculator) Add(req Req, res *Res) error {
+	res.Num = req.NumOne + req.NumTwo
+	return nil
+}
+
+func (c *Calculator) Sub(req Req, res *Res) error {
+	res.Num = req.NumOne - req.NumTwo
+	return nil
+}
+
+func (c *Calculator) Mul(req Req, res *Res) error {
+	res.Num = req.NumOne * req.NumTwo
+	return nil
+}
+
+func (c *Calculator) Div(req Req, res *Res) error {
+	if req.NumTwo == 0 {
+		return ErrDivideByZero
+	}
+	res.Num = req.NumOne / req.NumTwo
+	return nil
+}
+
+// Op 是Eval中的一次运算，Op为Add、Sub、Mul或Div
+type Op struct {
+	Op string
+	Req
+}
+
+type EvalReq struct {
+	Ops []Op
+}
+
+// Result 是一次运算的结果，出错时Error不为空
+type Result struct {
+	Num   int
+	Error string
+}
+
+type EvalRes struct {
+	Results []Result
+}
+
+// Eval 一次执行多个运算，单个运算出错不影响其他运算
+func (c *Calculator) Eval(req EvalReq, res *EvalRes) error {
+	res.Results = make([]Result, len(req.Ops))
+	for i, op := range req.Ops {
+		var f func(Req, *Res) error
+		switch op.Op {
+		case "Add":
+			f = c.Add
+		case "Sub":
+			f = c.Sub
+		case "Mul":
+			f = c.Mul
+		case "Div":
+			f = c.Div
+		default:
+			res.Results[i].Error = "unknown op " + op.Op
+			continue
+		}
+		var r Res
+		if err := f(op.Req, &r); err != nil {
+			res.Results[i].Error = err.Error()
+			continue
+		}
+		res.Results[i].Num = r.Num
+	}
+	return nil
+}
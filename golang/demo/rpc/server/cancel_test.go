@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"gopl.io/demo/rpc/rpcctx"
+)
+
+// recorder 把每次调用的结果发送到calls
+type recorder struct {
+	calls chan *Call
+}
+
+func (r recorder) Before(c *Call) error { return nil }
+func (r recorder) After(c *Call)        { r.calls <- c }
+
+// newTestClient 在net.Pipe上启动一个进程内的服务端，返回连接它的客户端
+func newTestClient(t *testing.T, rcvrs ...interface{}) (*rpc.Client, recorder) {
+	t.Helper()
+	srv := rpc.NewServer()
+	for _, rcvr := range rcvrs {
+		if err := srv.Register(rcvr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := recorder{calls: make(chan *Call, 16)}
+	sc, cc := net.Pipe()
+	go srv.ServeCodec(newInterceptCodec(newGobServerCodec(sc), nil, rec))
+	client := rpc.NewClient(cc)
+	t.Cleanup(func() { client.Close() })
+	return client, rec
+}
+
+// waitCall 等待method的调用结束
+func waitCall(t *testing.T, rec recorder, method string) *Call {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case c := <-rec.calls:
+			if c.Method == method {
+				return c
+			}
+		case <-timeout:
+			t.Fatalf("%s did not return on the server", method)
+			return nil
+		}
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     func() (context.Context, context.CancelFunc)
+		want    error
+		wantSrv string // 服务端Server.Add返回的错误
+	}{
+		{
+			name: "deadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			want:    context.DeadlineExceeded,
+			wantSrv: context.DeadlineExceeded.Error(),
+		},
+		{
+			name: "cancel",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			want:    context.Canceled,
+			wantSrv: context.Canceled.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, rec := newTestClient(t, &Server{calls: rpcctx.NewCalls()})
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			start := time.Now()
+			var res Res
+			err := rpcctx.Call(ctx, client, "Server.Add", &Req{NumOne: 1, NumTwo: 2}, &res)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Call() error = %v, want %v", err, tt.want)
+			}
+			if d := time.Since(start); d > time.Second {
+				t.Errorf("Call() returned after %s", d)
+			}
+
+			// Server.Add需要5秒，服务端的ctx被取消后应该立即返回
+			c := waitCall(t, rec, "Server.Add")
+			if c.Err == nil || c.Err.Error() != tt.wantSrv {
+				t.Errorf("Server.Add error = %v, want %s", c.Err, tt.wantSrv)
+			}
+		})
+	}
+}
+
+func TestCallContextNoCancel(t *testing.T) {
+	client, _ := newTestClient(t, new(Calculator))
+	var res Res
+	err := rpcctx.Call(context.Background(), client, "Calculator.Add", &Req{NumOne: 1, NumTwo: 2}, &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Num != 3 {
+		t.Errorf("Calculator.Add = %d, want 3", res.Num)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Call 描述一次调用，在拦截器之间传递
+type Call struct {
+	Method   string
+	Seq      uint64
+	Args     interface{} // 解码后的参数
+	Meta     http.Header // 客户端建立连接时带上的header
+	Start    time.Time
+	Duration time.Duration // After时有效
+	Err      error         // After时有效，方法返回的错误或者拦截器拒绝的原因
+}
+
+// Interceptor 在每次调用前后被调用。
+//
+// Before按顺序调用，返回错误时后面的拦截器和方法都不会执行，错误直接返回给客户端；
+// After按相反的顺序调用，只有Before成功的拦截器才会调用After
+type Interceptor interface {
+	Before(c *Call) error
+	After(c *Call)
+}
+
+// interceptCodec 包装一个rpc.ServerCodec，在每次调用前后执行拦截器。
+// net/rpc在ReadRequestBody返回错误时不会调用方法，而是把错误作为结果返回，
+// 所以Before在ReadRequestBody里执行，After在WriteResponse里执行
+type interceptCodec struct {
+	rpc.ServerCodec
+	meta  http.Header
+	chain []Interceptor
+
+	cur *Call // ReadRequestHeader和ReadRequestBody在同一个goroutine里按顺序调用
+
+	mu      sync.Mutex
+	pending map[uint64]*pendingCall
+}
+
+type pendingCall struct {
+	*Call
+	passed int // Before成功的拦截器数
+}
+
+func newInterceptCodec(codec rpc.ServerCodec, meta http.Header, chain ...Interceptor) rpc.ServerCodec {
+	if len(chain) == 0 {
+		return codec
+	}
+	return &interceptCodec{
+		ServerCodec: codec,
+		meta:        meta,
+		chain:       chain,
+		pending:     make(map[uint64]*pendingCall),
+	}
+}
+
+func (c *interceptCodec) ReadRequestHeader(r *rpc.Request) error {
+	c.cur = nil
+	if err := c.ServerCodec.ReadRequestHeader(r); err != nil {
+		return err
+	}
+	c.cur = &Call{Method: r.ServiceMethod, Seq: r.Seq, Meta: c.meta, Start: time.Now()}
+	return nil
+}
+
+func (c *interceptCodec) ReadRequestBody(body interface{}) error {
+	if err := c.ServerCodec.ReadRequestBody(body); err != nil {
+		return err
+	}
+	call := c.cur
+	if call == nil {
+		return nil
+	}
+	if body != nil {
+		call.Args = reflect.ValueOf(body).Elem().Interface()
+	}
+
+	p := &pendingCall{Call: call}
+	c.mu.Lock()
+	c.pending[call.Seq] = p
+	c.mu.Unlock()
+
+	for _, in := range c.chain {
+		if err := in.Before(call); err != nil {
+			call.Err = err
+			return err
+		}
+		p.passed++
+	}
+	return nil
+}
+
+func (c *interceptCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.mu.Lock()
+	p := c.pending[r.Seq]
+	delete(c.pending, r.Seq)
+	c.mu.Unlock()
+
+	err := c.ServerCodec.WriteResponse(r, body)
+	if p == nil {
+		return err
+	}
+	p.Duration = time.Since(p.Start)
+	if p.Err == nil && r.Error != "" {
+		p.Err = rpc.ServerError(r.Error)
+	}
+	for i := p.passed - 1; i >= 0; i-- {
+		c.chain[i].After(p.Call)
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger 记录每次调用
+type Logger struct{}
+
+func (Logger) Before(c *Call) error { return nil }
+
+func (Logger) After(c *Call) {
+	log.Printf("event=call method=%s seq=%d args=%+v duration=%s err=%v", c.Method, c.Seq, c.Args, c.Duration, c.Err)
+}
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Auth 要求客户端建立连接时带上header Authorization: Bearer <Secret>
+type Auth struct {
+	Secret string
+}
+
+func (a Auth) Before(c *Call) error {
+	token := strings.TrimPrefix(c.Meta.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.Secret)) != 1 {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+func (a Auth) After(c *Call) {}
+
+// latencyBuckets 是直方图每个桶的上限
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+type histogram struct {
+	counts []int64 // counts[i]是落在第i个桶的调用数，最后一个是超过所有上限的
+	count  int64
+	errors int64
+	sum    time.Duration
+}
+
+// Latency 按方法统计调用耗时的直方图，ServeHTTP以Prometheus的文本格式输出
+type Latency struct {
+	mu      sync.Mutex
+	methods map[string]*histogram
+}
+
+func NewLatency() *Latency {
+	return &Latency{methods: make(map[string]*histogram)}
+}
+
+func (l *Latency) Before(c *Call) error { return nil }
+
+func (l *Latency) After(c *Call) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h := l.methods[c.Method]
+	if h == nil {
+		h = &histogram{counts: make([]int64, len(latencyBuckets)+1)}
+		l.methods[c.Method] = h
+	}
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return c.Duration <= latencyBuckets[i] })
+	h.counts[i]++
+	h.count++
+	h.sum += c.Duration
+	if c.Err != nil {
+		h.errors++
+	}
+}
+
+func (l *Latency) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var methods []string
+	for m := range l.methods {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, m := range methods {
+		h := l.methods[m]
+		var cum int64
+		for i, le := range latencyBuckets {
+			cum += h.counts[i]
+			fmt.Fprintf(w, "rpc_latency_seconds_bucket{method=%q,le=\"%g\"} %d\n", m, le.Seconds(), cum)
+		}
+		fmt.Fprintf(w, "rpc_latency_seconds_bucket{method=%q,le=\"+Inf\"} %d\n", m, h.count)
+		fmt.Fprintf(w, "rpc_latency_seconds_sum{method=%q} %g\n", m, h.sum.Seconds())
+		fmt.Fprintf(w, "rpc_latency_seconds_count{method=%q} %d\n", m, h.count)
+		fmt.Fprintf(w, "rpc_errors_total{method=%q} %d\n", m, h.errors)
+	}
+}
@@ -1,18 +1,25 @@
 package main
 
 import (
-	"fmt"
+	"flag"
+	"log"
 	"net"
 	"net/http"
 	"net/rpc"
+	"os"
+	"os/signal"
 	"time"
+
+	"gopl.io/demo/rpc/rpcctx"
 )
 
 type Server struct {
+	calls *rpcctx.Calls
 }
 
 type Req struct {
 	NumOne, NumTwo int
+	rpcctx.CallCtx
 }
 
 type Res struct {
@@ -20,17 +27,91 @@ type Res struct {
 }
 
 func (s *Server) Add(req Req, res *Res) error {
-	time.Sleep(5 * time.Second)
+	ctx, done := s.calls.Begin(req.CallCtx)
+	defer done()
+
+	// 模拟耗时操作，客户端取消或超过截止时间时提前返回
+	select {
+	case <-time.After(5 * time.Second):
+	case <-ctx.Done():
+		log.Printf("Server.Add(%d) canceled: %v", req.CallID, ctx.Err())
+		return ctx.Err()
+	}
 	res.Num = req.NumTwo + req.NumOne
 	return nil
 }
 
+// Cancel 取消CallID为id的调用
+func (s *Server) Cancel(id uint64, _ *struct{}) error {
+	s.calls.Cancel(id)
+	return nil
+}
+
 func main() {
-	rpc.Register(new(Server))
+	httpAddr := flag.String("http", ":8888", "listen address of net/rpc over HTTP")
+	jsonAddr := flag.String("json", ":8889", "listen address of JSON-RPC over TCP")
+	registry := flag.String("registry", "", "registry URL, e.g. http://localhost:8890 (empty: don't register)")
+	service := flag.String("service", "rpc", "service name to register")
+	advertiseAddr := flag.String("advertise", "", "address registered to the registry (default localhost:<http port>)")
+	secret := flag.String("secret", "", "shared secret clients must send as Authorization: Bearer <secret> (empty: no auth)")
+	logCalls := flag.Bool("log", true, "log every call")
+	flag.Parse()
+
+	// 拦截器按顺序执行，被Auth拒绝的调用也会被记录和统计
+	latency := NewLatency()
+	chain := []Interceptor{latency}
+	if *logCalls {
+		chain = append([]Interceptor{Logger{}}, chain...)
+	}
+	if *secret != "" {
+		chain = append(chain, Auth{Secret: *secret})
+	}
+
+	srv := &Server{calls: rpcctx.NewCalls()}
+	calc := new(Calculator)
+	streams := NewStream()
+	rpc.Register(srv)
+	rpc.Register(calc)
+	rpc.Register(streams)
+
+	// JSON-RPC，非Go的程序也可以调用，例如：
+	// echo '{"method":"Calculator.Div","params":[{"NumOne":10,"NumTwo":2}],"id":1}' | nc localhost 8889
+	// 设置了-secret时需要先发送header：
+	// printf 'Authorization: Bearer s3cret\r\n\r\n{"method":"Calculator.Div","params":[{"NumOne":10,"NumTwo":2}],"id":1}' | nc localhost 8889
+	jl, err := net.Listen("tcp", *jsonAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go serveJSON(jl, chain...)
+
+	// gob编码，走HTTP
+	// rpc.HandleHTTP注册/debug/rpc，它注册的DefaultRPCPath不经过拦截器，
+	// 所以DefaultRPCPath由外面的mux交给rpcHandler，其他的才交给DefaultServeMux
 	rpc.HandleHTTP()
-	l, e := net.Listen("tcp", ":8888")
+	http.Handle("/debug/rpc/latency", latency)
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, rpcHandler{chain})
+	mux.Handle("/", http.DefaultServeMux)
+	l, e := net.Listen("tcp", *httpAddr)
 	if e != nil {
-		fmt.Println("你玩了你错了")
+		log.Fatal("你玩了你错了", e)
+	}
+
+	if *registry != "" {
+		reg, err := register(*registry, *service, advertise(*advertiseAddr, l), srv, calc, streams)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// 收到Ctrl-C时先从registry注销再退出
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		go func() {
+			<-sig
+			if err := reg.Close(); err != nil {
+				log.Print(err)
+			}
+			os.Exit(0)
+		}()
 	}
-	http.Serve(l, nil)
+	http.Serve(l, mux)
 }
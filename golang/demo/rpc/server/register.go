@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"net"
+	"reflect"
+	"time"
+
+	"gopl.io/demo/rpc/discovery"
+)
+
+// register 把地址为addr的实例注册到registry
+func register(registry, service, addr string, rcvrs ...interface{}) (*discovery.Registration, error) {
+	inst := discovery.Instance{Service: service, Addr: addr}
+	for _, rcvr := range rcvrs {
+		inst.Methods = append(inst.Methods, methods(rcvr)...)
+	}
+	reg, err := discovery.Register(registry, inst, 3*time.Second, func(err error) {
+		log.Printf("event=heartbeat_failed registry=%s err=%v", registry, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("event=register registry=%s service=%s addr=%s methods=%v", registry, service, addr, inst.Methods)
+	return reg, nil
+}
+
+// methods 返回rcvr中可以通过net/rpc调用的方法，格式和rpc.Register一样是Type.Method
+func methods(rcvr interface{}) []string {
+	t := reflect.TypeOf(rcvr)
+	name := reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name()
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	var list []string
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		mt := m.Type
+		if !m.IsExported() || mt.NumIn() != 3 || mt.NumOut() != 1 || mt.Out(0) != errorType {
+			continue
+		}
+		if mt.In(2).Kind() != reflect.Pointer {
+			continue
+		}
+		list = append(list, name+"."+m.Name)
+	}
+	return list
+}
+
+// advertise 返回注册到registry的地址，没有指定时用localhost加上实际监听的端口
+func advertise(addr string, l net.Listener) string {
+	if addr != "" {
+		return addr
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return net.JoinHostPort("localhost", port)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"gopl.io/demo/rpc/rpcctx"
+)
+
+// net/rpc一次调用只有一个返回值，流式调用分成三步：
+// 先调用Stream.Add之类的方法启动，得到流的ID；
+// 然后反复调用Stream.Next取部分结果，直到EOF；
+// 客户端提前放弃时调用Stream.Close。
+
+const (
+	pollWait   = 5 * time.Second  // Next最多等待多久
+	streamIdle = 30 * time.Second // 超过这个时间没有Next的流会被取消
+)
+
+var ErrNoStream = errors.New("no such stream")
+
+// Event 是流式调用的一条部分结果
+type Event struct {
+	Progress int  // 0到100
+	Final    bool // 为true时Num是最终结果，这是流的最后一条
+	Num      int
+}
+
+// NextRes 是Stream.Next的结果。
+// EOF为true表示流已经结束，之后不会再有Event，Error不为空表示流因为出错而结束
+type NextRes struct {
+	Events []Event
+	EOF    bool
+	Error  string
+}
+
+type stream struct {
+	events chan Event
+	done   chan struct{} // events不会再有新的Event时关闭，之后err有效
+	err    error
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	lastPoll time.Time
+}
+
+// Stream 提供流式调用
+type Stream struct {
+	mu      sync.Mutex
+	streams map[uint64]*stream
+}
+
+func NewStream() *Stream {
+	s := &Stream{streams: make(map[uint64]*stream)}
+	go s.reap()
+	return s
+}
+
+// addStep 是Stream.Add每次报告进度的间隔，测试时可以调小
+var addStep = 500 * time.Millisecond
+
+// Add 和Server.Add一样需要5秒，期间每半秒报告一次进度
+func (s *Stream) Add(req Req, id *uint64) error {
+	step := addStep
+	*id = s.start(req.CallCtx, func(ctx context.Context, emit func(Event) error) error {
+		for p := 0; p < 100; p += 10 {
+			if err := emit(Event{Progress: p}); err != nil {
+				return err
+			}
+			select {
+			case <-time.After(step):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return emit(Event{Progress: 100, Final: true, Num: req.NumOne + req.NumTwo})
+	})
+	return nil
+}
+
+// Next 返回id已经产生的部分结果，没有时最多等待pollWait
+func (s *Stream) Next(id uint64, res *NextRes) error {
+	st := s.get(id)
+	if st == nil {
+		return ErrNoStream
+	}
+	st.mu.Lock()
+	st.lastPoll = time.Now()
+	st.mu.Unlock()
+
+	// 等待第一条，然后取走所有已经缓存的
+	select {
+	case e := <-st.events:
+		res.Events = append(res.Events, e)
+	case <-st.done:
+	case <-time.After(pollWait):
+		return nil
+	}
+	for drained := false; !drained; {
+		select {
+		case e := <-st.events:
+			res.Events = append(res.Events, e)
+		default:
+			drained = true
+		}
+	}
+
+	select {
+	case <-st.done:
+		// done之后events不会再增加，再取一次确保没有遗漏
+		for len(st.events) > 0 {
+			res.Events = append(res.Events, <-st.events)
+		}
+		res.EOF = true
+		if st.err != nil {
+			res.Error = st.err.Error()
+		}
+		s.remove(id)
+	default:
+	}
+	return nil
+}
+
+// Close 取消id，流已经结束时什么都不做
+func (s *Stream) Close(id uint64, _ *struct{}) error {
+	st := s.get(id)
+	if st == nil {
+		return nil
+	}
+	st.cancel()
+	s.remove(id)
+	return nil
+}
+
+// start 在新的goroutine里执行fn，fn通过emit发送部分结果，返回流的ID。
+// 客户端取不过来时emit会阻塞，流被取消时emit返回错误
+func (s *Stream) start(cc rpcctx.CallCtx, fn func(ctx context.Context, emit func(Event) error) error) uint64 {
+	ctx, cancel := rpcctx.Context(cc)
+	st := &stream{
+		events:   make(chan Event, 16),
+		done:     make(chan struct{}),
+		cancel:   cancel,
+		lastPoll: time.Now(),
+	}
+
+	s.mu.Lock()
+	id := newStreamID()
+	for s.streams[id] != nil {
+		id = newStreamID()
+	}
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		emit := func(e Event) error {
+			select {
+			case st.events <- e:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		st.err = fn(ctx, emit)
+		close(st.done)
+	}()
+	return id
+}
+
+// newStreamID 返回一个随机的流ID。流不属于某个连接，
+// 知道ID就可以调用Next和Close，所以ID不能被猜到
+func newStreamID() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func (s *Stream) get(id uint64) *stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+func (s *Stream) remove(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+}
+
+// reap 取消客户端已经不再读取的流
+func (s *Stream) reap() {
+	for now := range time.Tick(streamIdle / 2) {
+		s.reapIdle(now)
+	}
+}
+
+// reapIdle 取消在now之前超过streamIdle没有调用Next的流
+func (s *Stream) reapIdle(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, st := range s.streams {
+		st.mu.Lock()
+		idle := now.Sub(st.lastPoll)
+		st.mu.Unlock()
+		if idle > streamIdle {
+			log.Printf("event=stream_idle id=%d idle=%s", id, idle)
+			st.cancel()
+			delete(s.streams, id)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+// setAddStep 在测试期间修改Stream.Add的进度间隔
+func setAddStep(t *testing.T, d time.Duration) {
+	old := addStep
+	addStep = d
+	t.Cleanup(func() { addStep = old })
+}
+
+// nextAll 反复调用Stream.Next直到EOF，返回所有Event和最后一次的结果
+func nextAll(t *testing.T, client *rpc.Client, id uint64) ([]Event, NextRes) {
+	t.Helper()
+	var events []Event
+	for i := 0; i < 100; i++ {
+		var res NextRes
+		if err := client.Call("Stream.Next", id, &res); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, res.Events...)
+		if res.EOF {
+			return events, res
+		}
+	}
+	t.Fatal("stream did not end")
+	return nil, NextRes{}
+}
+
+// waitDone 等待服务端的流结束，返回结束的原因
+func waitDone(t *testing.T, st *stream) error {
+	t.Helper()
+	select {
+	case <-st.done:
+		return st.err
+	case <-time.After(time.Second):
+		t.Fatal("stream was not canceled")
+		return nil
+	}
+}
+
+func expectNoStream(t *testing.T, client *rpc.Client, id uint64) {
+	t.Helper()
+	var res NextRes
+	if err := client.Call("Stream.Next", id, &res); err == nil || err.Error() != ErrNoStream.Error() {
+		t.Errorf("Next after the stream ended error = %v, want %v", err, ErrNoStream)
+	}
+}
+
+func TestStreamEOF(t *testing.T) {
+	setAddStep(t, time.Millisecond)
+	client, _ := newTestClient(t, NewStream())
+	var id uint64
+	if err := client.Call("Stream.Add", &Req{NumOne: 1, NumTwo: 2}, &id); err != nil {
+		t.Fatal(err)
+	}
+	events, res := nextAll(t, client, id)
+	if res.Error != "" {
+		t.Fatalf("Error = %q, want none", res.Error)
+	}
+	if len(events) != 11 {
+		t.Fatalf("got %d events, want 11", len(events))
+	}
+	for i, e := range events[:10] {
+		if e.Final || e.Progress != i*10 {
+			t.Errorf("event %d = %+v, want progress %d", i, e, i*10)
+		}
+	}
+	if last := events[10]; !last.Final || last.Progress != 100 || last.Num != 3 {
+		t.Errorf("last event = %+v, want final 3", last)
+	}
+	expectNoStream(t, client, id)
+}
+
+func TestStreamError(t *testing.T) {
+	setAddStep(t, time.Hour)
+	client, _ := newTestClient(t, NewStream())
+	req := &Req{NumOne: 1, NumTwo: 2}
+	req.Deadline = time.Now().Add(50 * time.Millisecond).UnixNano()
+	var id uint64
+	if err := client.Call("Stream.Add", req, &id); err != nil {
+		t.Fatal(err)
+	}
+	events, res := nextAll(t, client, id)
+	if res.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("Error = %q, want %q", res.Error, context.DeadlineExceeded)
+	}
+	for _, e := range events {
+		if e.Final {
+			t.Errorf("got final event %+v from a failed stream", e)
+		}
+	}
+	expectNoStream(t, client, id)
+}
+
+func TestStreamClose(t *testing.T) {
+	setAddStep(t, time.Hour)
+	streams := NewStream()
+	client, _ := newTestClient(t, streams)
+	var id uint64
+	if err := client.Call("Stream.Add", &Req{}, &id); err != nil {
+		t.Fatal(err)
+	}
+	st := streams.get(id)
+	if err := client.Call("Stream.Close", id, new(struct{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitDone(t, st); err != context.Canceled {
+		t.Errorf("stream ended with %v, want %v", err, context.Canceled)
+	}
+	expectNoStream(t, client, id)
+	// 再次Close不会出错
+	if err := client.Call("Stream.Close", id, new(struct{})); err != nil {
+		t.Errorf("second Close error = %v", err)
+	}
+}
+
+func TestStreamIdle(t *testing.T) {
+	setAddStep(t, time.Hour)
+	streams := NewStream()
+	client, _ := newTestClient(t, streams)
+	var id uint64
+	if err := client.Call("Stream.Add", &Req{}, &id); err != nil {
+		t.Fatal(err)
+	}
+	st := streams.get(id)
+
+	// 刚刚启动的流不会被取消
+	streams.reapIdle(time.Now())
+	if streams.get(id) == nil {
+		t.Fatal("active stream was reaped")
+	}
+	streams.reapIdle(time.Now().Add(streamIdle + time.Second))
+	if err := waitDone(t, st); err != context.Canceled {
+		t.Errorf("stream ended with %v, want %v", err, context.Canceled)
+	}
+	expectNoStream(t, client, id)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"net/textproto"
+)
+
+// rpcHandler 和rpc.Server.ServeHTTP一样处理CONNECT，
+// 但会把请求的header交给拦截器，客户端可以在CONNECT里带上认证信息
+type rpcHandler struct {
+	chain []Interceptor
+}
+
+func (h rpcHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "CONNECT" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must CONNECT\n")
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		log.Print("rpc hijacking ", req.RemoteAddr, ": ", err.Error())
+		return
+	}
+	io.WriteString(conn, "HTTP/1.0 200 Connected to Go RPC\n\n")
+	rpc.ServeCodec(newInterceptCodec(newGobServerCodec(conn), req.Header, h.chain...))
+}
+
+// serveJSON 处理JSON-RPC连接。
+// 连接开头可以有一段和HTTP一样的header，以空行结束，例如：
+//
+//	Authorization: Bearer s3cret
+//
+//	{"method":"Calculator.Div","params":[{"NumOne":10,"NumTwo":2}],"id":1}
+func serveJSON(l net.Listener, chain ...Interceptor) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		go func() {
+			br := bufio.NewReader(conn)
+			meta, err := readMeta(br)
+			if err != nil {
+				log.Printf("event=bad_header remote=%s err=%v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			codec := jsonrpc.NewServerCodec(bufferedConn{br, conn})
+			rpc.ServeCodec(newInterceptCodec(codec, meta, chain...))
+		}()
+	}
+}
+
+// readMeta 读取JSON之前的header，没有header时返回空的http.Header。
+// 开头的空白会被跳过，JSON前面带换行的客户端不会被当成header
+func readMeta(br *bufio.Reader) (http.Header, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil || b[0] == '{' {
+			return http.Header{}, nil
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+	h, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	return http.Header(h), nil
+}
+
+// bufferedConn 从br读取，br可能已经缓存了conn的数据
+type bufferedConn struct {
+	br *bufio.Reader
+	net.Conn
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) { return c.br.Read(p) }
+
+// gobServerCodec 和net/rpc内部的实现一样，net/rpc没有导出它
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+func newGobServerCodec(conn io.ReadWriteCloser) *gobServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobServerCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+	}
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: gob error encoding response:", err)
+			c.Close()
+		}
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: gob error encoding body:", err)
+			c.Close()
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
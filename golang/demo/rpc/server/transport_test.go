@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadMeta(t *testing.T) {
+	const body = `{"method":"Calculator.Add","params":[{}],"id":1}`
+	tests := []struct {
+		name string
+		in   string
+		auth string
+	}{
+		{"json only", body, ""},
+		{"leading newline", "\n" + body, ""},
+		{"leading whitespace", " \r\n\t" + body, ""},
+		{"header", "Authorization: Bearer s3cret\r\n\r\n" + body, "Bearer s3cret"},
+		{"header after blank line", "\r\nAuthorization: Bearer s3cret\r\n\r\n" + body, "Bearer s3cret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(tt.in))
+			meta, err := readMeta(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := meta.Get("Authorization"); got != tt.auth {
+				t.Errorf("Authorization = %q, want %q", got, tt.auth)
+			}
+			rest, _ := io.ReadAll(br)
+			if string(rest) != body {
+				t.Errorf("remaining = %q, want %q", rest, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"gopl.io/demo/tcp/proto"
+)
+
+var ErrQueueFull = errors.New("client: send queue full")
+
+// State 是Client的连接状态
+type State int
+
+const (
+	Disconnected State = iota
+	Connecting
+	Connected
+	Closed
+)
+
+func (s State) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Closed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Client 是一个断线后自动重连的客户端。断线期间Send的消息先缓存起来，
+// 重新连上之后按顺序发出去。已经写入连接但对端没来得及处理的消息不会重发
+type Client struct {
+	Dial       func() (net.Conn, error)
+	MinBackoff time.Duration // 第一次重连前的等待时间
+	MaxBackoff time.Duration // 重连等待时间的上限
+	MaxPending int           // 最多缓存的消息数，0表示不限制
+
+	// StableAfter 连接保持这么久才算连接成功，之后的重连等待时间重新从MinBackoff开始，默认5秒。
+	// 连上后立即被关闭的连接(服务端连接数已满、mTLS校验失败)不会让等待时间复位
+	StableAfter time.Duration
+
+	OnState   func(s State, err error) // 状态变化时调用，err是断开的原因
+	OnMessage func(m proto.Message)    // 收到Text消息时调用
+
+	mu        sync.Mutex
+	pending   []proto.Message
+	notify    chan struct{} // 有新消息进入队列
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *Client) init() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.notify == nil {
+		c.notify = make(chan struct{}, 1)
+		c.closed = make(chan struct{})
+	}
+}
+
+// Send 把消息放进发送队列，队列满时返回ErrQueueFull。
+// 消息发出之前调用者不能再修改m.Payload
+func (c *Client) Send(m proto.Message) error {
+	c.init()
+	c.mu.Lock()
+	if c.MaxPending > 0 && len(c.pending) >= c.MaxPending {
+		c.mu.Unlock()
+		return ErrQueueFull
+	}
+	c.pending = append(c.pending, m)
+	c.mu.Unlock()
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close 让Client停止重连。如果当前已连接，Run会先把队列里的消息发完，
+// 关闭写端并等服务端关闭连接后再返回
+func (c *Client) Close() {
+	c.init()
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+// Run 连接服务端并在断线后重连，直到Close被调用。
+// 在第一次连接之前就被Close时，如果队列里有消息，仍会尝试连接一次把它们发出去
+func (c *Client) Run() {
+	c.init()
+	for attempt := 0; ; attempt++ {
+		if c.isClosed() && (attempt > 0 || c.queued() == 0) {
+			break
+		}
+		c.setState(Connecting, nil)
+		conn, err := c.Dial()
+		if err == nil {
+			c.setState(Connected, nil)
+			start := time.Now()
+			err = c.session(conn)
+			if c.isClosed() {
+				break
+			}
+			if time.Since(start) >= c.stableAfter() {
+				attempt = 0
+			}
+		}
+		c.setState(Disconnected, err)
+
+		select {
+		case <-time.After(c.backoff(attempt)):
+		case <-c.closed:
+		}
+	}
+	c.setState(Closed, nil)
+}
+
+func (c *Client) stableAfter() time.Duration {
+	if c.StableAfter > 0 {
+		return c.StableAfter
+	}
+	return 5 * time.Second
+}
+
+// backoff 返回第attempt次重连前的等待时间：指数增长，并在[d/2, d)之间随机，
+// 避免大量客户端在服务端重启后同时重连
+func (c *Client) backoff(attempt int) time.Duration {
+	min, max := c.MinBackoff, c.MaxBackoff
+	if min <= 0 {
+		min = 100 * time.Millisecond
+	}
+	if max < min {
+		max = min
+	}
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (c *Client) session(conn net.Conn) error {
+	enc := proto.NewEncoder(conn)
+	dec := proto.NewDecoder(conn)
+	stop := make(chan struct{})
+	errc := make(chan error, 2)
+	go func() { errc <- c.readLoop(dec, enc) }()
+	go func() { errc <- c.writeLoop(conn, enc, stop) }()
+
+	err := <-errc
+	if err == nil {
+		// 写端在Close之后正常结束，等服务端把剩下的回复发完再关闭
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		err = <-errc
+		conn.Close()
+		return err
+	}
+	close(stop)
+	conn.Close()
+	<-errc
+	return err
+}
+
+func (c *Client) readLoop(dec *proto.Decoder, enc *proto.Encoder) error {
+	for {
+		m, err := dec.Decode()
+		if err != nil {
+			return err
+		}
+		switch m.Type {
+		case proto.Ping:
+			if err := enc.Encode(proto.Message{Type: proto.Pong}); err != nil {
+				return err
+			}
+		case proto.Text:
+			if c.OnMessage != nil {
+				c.OnMessage(m)
+			}
+		}
+	}
+}
+
+// writeLoop 按顺序发送队列里的消息，消息写成功后才从队列中移除
+func (c *Client) writeLoop(conn net.Conn, enc *proto.Encoder, stop <-chan struct{}) error {
+	for {
+		c.mu.Lock()
+		var m proto.Message
+		ok := len(c.pending) > 0
+		if ok {
+			m = c.pending[0]
+		}
+		c.mu.Unlock()
+
+		if ok {
+			if err := enc.Encode(m); err != nil {
+				return err
+			}
+			c.mu.Lock()
+			c.pending = c.pending[1:]
+			c.mu.Unlock()
+			continue
+		}
+
+		select {
+		case <-c.notify:
+		case <-stop:
+			return nil
+		case <-c.closed:
+			if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			}
+			return nil
+		}
+	}
+}
+
+func (c *Client) queued() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) setState(s State, err error) {
+	if c.OnState != nil {
+		c.OnState(s, err)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"gopl.io/demo/tcp/proto"
+)
+
+// testServer 是一个可以停止后在同一地址重启的回环服务端，把收到的Text放进received
+type testServer struct {
+	t        *testing.T
+	addr     string
+	received chan string
+
+	mu    sync.Mutex
+	l     net.Listener
+	conns map[net.Conn]bool
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{t: t, received: make(chan string, 100)}
+	s.start("127.0.0.1:0")
+	t.Cleanup(s.stop)
+	return s
+}
+
+func (s *testServer) start(addr string) {
+	s.t.Helper()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.l, s.addr, s.conns = l, l.Addr().String(), make(map[net.Conn]bool)
+	s.mu.Unlock()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns[c] = true
+			s.mu.Unlock()
+			go s.serve(c)
+		}
+	}()
+}
+
+func (s *testServer) serve(c net.Conn) {
+	defer c.Close()
+	dec := proto.NewDecoder(c)
+	for {
+		m, err := dec.Decode()
+		if err != nil {
+			return
+		}
+		if m.Type == proto.Text {
+			s.received <- string(m.Payload)
+		}
+	}
+}
+
+// stop 关闭listener和所有连接，模拟服务端重启
+func (s *testServer) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.l.Close()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+func (s *testServer) dial() (net.Conn, error) {
+	s.mu.Lock()
+	addr := s.addr
+	s.mu.Unlock()
+	return net.Dial("tcp", addr)
+}
+
+func (s *testServer) expect(want ...string) {
+	s.t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-s.received:
+			if got != w {
+				s.t.Fatalf("received %q, want %q", got, w)
+			}
+		case <-time.After(2 * time.Second):
+			s.t.Fatalf("timed out waiting for %q", w)
+		}
+	}
+}
+
+// stateRecorder 记录OnState的调用，wait等待某个状态出现
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []State
+	errs   []error
+	ch     chan State
+}
+
+func newStateRecorder() *stateRecorder {
+	return &stateRecorder{ch: make(chan State, 100)}
+}
+
+func (r *stateRecorder) record(s State, err error) {
+	r.mu.Lock()
+	r.states = append(r.states, s)
+	r.errs = append(r.errs, err)
+	r.mu.Unlock()
+	select {
+	case r.ch <- s:
+	default: // 没有人wait时不阻塞Run
+	}
+}
+
+func (r *stateRecorder) wait(t *testing.T, want State) {
+	t.Helper()
+	for {
+		select {
+		case s := <-r.ch:
+			if s == want {
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for state %s", want)
+		}
+	}
+}
+
+func TestReconnectReplay(t *testing.T) {
+	srv := newTestServer(t)
+	rec := newStateRecorder()
+	c := &Client{
+		Dial:       srv.dial,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+		OnState:    rec.record,
+	}
+	done := make(chan struct{})
+	go func() {
+		c.Run()
+		close(done)
+	}()
+
+	rec.wait(t, Connected)
+	c.Send(proto.Message{Type: proto.Text, Payload: []byte("1")})
+	srv.expect("1")
+
+	srv.stop()
+	rec.wait(t, Disconnected)
+	// 断线期间的消息先缓存，重连后按顺序发出
+	for _, p := range []string{"2", "3", "4"} {
+		if err := c.Send(proto.Message{Type: proto.Text, Payload: []byte(p)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv.start(srv.addr)
+	srv.expect("2", "3", "4")
+
+	c.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after Close")
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if first, last := rec.states[0], rec.states[len(rec.states)-1]; first != Connecting || last != Closed {
+		t.Errorf("states %v, want Connecting first and Closed last", rec.states)
+	}
+	connected := 0
+	for i, s := range rec.states {
+		switch s {
+		case Connected:
+			connected++
+			if i == 0 || rec.states[i-1] != Connecting {
+				t.Errorf("states %v: Connected not preceded by Connecting", rec.states)
+			}
+		case Disconnected:
+			if rec.errs[i] == nil {
+				t.Errorf("Disconnected without a reason")
+			}
+		}
+	}
+	if connected != 2 {
+		t.Errorf("connected %d times, want 2: %v", connected, rec.states)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	c := &Client{MaxPending: 2}
+	for i := 0; i < 2; i++ {
+		if err := c.Send(proto.Message{Type: proto.Text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Send(proto.Message{Type: proto.Text}); err != ErrQueueFull {
+		t.Errorf("Send() error = %v, want %v", err, ErrQueueFull)
+	}
+}
+
+// dialTimes 运行一个每次连上就被服务端关闭的Client，返回前n次Dial的时间
+func dialTimes(t *testing.T, c *Client, n int) []time.Time {
+	t.Helper()
+	var mu sync.Mutex
+	var times []time.Time
+	enough := make(chan struct{})
+	c.Dial = func() (net.Conn, error) {
+		mu.Lock()
+		times = append(times, time.Now())
+		if len(times) == n {
+			close(enough)
+		}
+		mu.Unlock()
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	done := make(chan struct{})
+	go func() {
+		c.Run()
+		close(done)
+	}()
+	select {
+	case <-enough:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("fewer than %d dials", n)
+	}
+	c.Close()
+	<-done
+	mu.Lock()
+	defer mu.Unlock()
+	return times[:n]
+}
+
+func TestBackoffReset(t *testing.T) {
+	const min = 50 * time.Millisecond
+
+	// 连接立即断开，不算连接成功，等待时间一直增长：第4次重连前至少等待min*8/2
+	c := &Client{MinBackoff: min, MaxBackoff: time.Second, StableAfter: time.Hour}
+	times := dialTimes(t, c, 5)
+	if gap := times[4].Sub(times[3]); gap < 4*min {
+		t.Errorf("gap before 5th dial = %v, want at least %v", gap, 4*min)
+	}
+
+	// 每个连接都超过StableAfter，等待时间每次都从MinBackoff开始
+	c = &Client{MinBackoff: min, MaxBackoff: time.Second, StableAfter: time.Nanosecond}
+	times = dialTimes(t, c, 5)
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap >= 3*min {
+			t.Errorf("gap before dial %d = %v, want about %v", i+1, gap, min)
+		}
+	}
+}
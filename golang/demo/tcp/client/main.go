@@ -2,9 +2,17 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"flag"
 	"fmt"
+	"io"
+	"log"
 	"net"
 	"os"
+	"time"
+
+	"gopl.io/demo/tcp/proto"
+	"gopl.io/demo/tcp/tlsconfig"
 )
 
 // 客户端通过tcp连接服务端
@@ -22,18 +30,72 @@ import (
 
 // 命令行发送文本
 func main() {
-	tcpAddr, _ := net.ResolveTCPAddr("tcp", ":8888")
-	conn, _ := net.DialTCP("tcp", nil, tcpAddr)
-	reader := bufio.NewReader(os.Stdin)
+	addr := flag.String("addr", "localhost:8888", "server address")
+	useTLS := flag.Bool("tls", false, "connect with TLS")
+	caFile := flag.String("ca", "", "CA file for verifying the server certificate (implies -tls)")
+	certFile := flag.String("cert", "", "client certificate file for mutual TLS (implies -tls)")
+	keyFile := flag.String("key", "", "client private key file")
+	flag.Parse()
+
+	dial, err := dialer(*addr, *useTLS || *caFile != "" || *certFile != "", *caFile, *certFile, *keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := &Client{
+		Dial:       dial,
+		MinBackoff: 200 * time.Millisecond,
+		MaxBackoff: 10 * time.Second,
+		MaxPending: 100,
+		OnState: func(s State, err error) {
+			if err != nil && err != io.EOF {
+				log.Printf("%s: %v", s, err)
+			} else {
+				log.Print(s)
+			}
+		},
+		OnMessage: func(m proto.Message) {
+			fmt.Println(string(m.Payload))
+		},
+	}
+	done := make(chan struct{})
+	go func() {
+		c.Run()
+		close(done)
+	}()
 
-	// 长连接
+	// 长连接，断线期间输入的内容会在重连后发出
+	reader := bufio.NewReader(os.Stdin)
 	for {
-		bytes, _, _ := reader.ReadLine()
-		conn.Write(bytes)
+		bytes, _, err := reader.ReadLine()
+		if err != nil {
+			break
+		}
+		// ReadLine返回的切片会被下一次读取覆盖，消息可能还在队列里，需要复制
+		payload := append([]byte(nil), bytes...)
+		if err := c.Send(proto.Message{Type: proto.Text, Payload: payload}); err != nil {
+			log.Print(err)
+		}
+	}
+	// 输入结束后发完剩下的消息，等服务端回复后退出
+	c.Close()
+	<-done
+}
 
-		// 接收服务端返回的信息
-		rb := make([]byte, 1024)
-		rn, _ := conn.Read(rb)
-		fmt.Println(string(rb[0:rn]))
+// dialer 返回连接服务端的函数，TLS配置只加载一次
+func dialer(addr string, useTLS bool, caFile, certFile, keyFile string) (func() (net.Conn, error), error) {
+	if !useTLS {
+		return func() (net.Conn, error) { return net.Dial("tcp", addr) }, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	cfg, err := tlsconfig.Client(caFile, certFile, keyFile, host)
+	if err != nil {
+		return nil, err
 	}
+	return func() (net.Conn, error) { return tls.Dial("tcp", addr, cfg) }, nil
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 生成一套只用于本地测试的证书：
+//
+//	ca.pem, ca-key.pem          自签名CA
+//	server.pem, server-key.pem  服务端证书，由CA签发
+//	client.pem, client-key.pem  客户端证书，用于双向认证
+func main() {
+	dir := flag.String("dir", "certs", "output directory")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,::1", "comma-separated host names and IPs for the server certificate")
+	clientCN := flag.String("client-cn", "client", "common name of the client certificate")
+	validFor := flag.Duration("valid-for", 24*time.Hour, "certificate lifetime")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+
+	notBefore := time.Now().Add(-time.Minute)
+	notAfter := notBefore.Add(*validFor)
+
+	caKey := newKey()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          serial(),
+		Subject:               pkix.Name{CommonName: "demo-tcp test CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER := create(caTmpl, caTmpl, caKey, caKey)
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		log.Fatal(err)
+	}
+	write(*dir, "ca", caDER, caKey)
+
+	serverTmpl := &x509.Certificate{
+		SerialNumber: serial(),
+		Subject:      pkix.Name{CommonName: "server"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range strings.Split(*hosts, ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		if ip := net.ParseIP(h); ip != nil {
+			serverTmpl.IPAddresses = append(serverTmpl.IPAddresses, ip)
+		} else {
+			serverTmpl.DNSNames = append(serverTmpl.DNSNames, h)
+		}
+	}
+	serverKey := newKey()
+	write(*dir, "server", create(serverTmpl, ca, serverKey, caKey), serverKey)
+
+	clientTmpl := &x509.Certificate{
+		SerialNumber: serial(),
+		Subject:      pkix.Name{CommonName: *clientCN},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientKey := newKey()
+	write(*dir, "client", create(clientTmpl, ca, clientKey, caKey), clientKey)
+
+	log.Printf("wrote certificates to %s", *dir)
+}
+
+func newKey() *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return key
+}
+
+func serial() *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return n
+}
+
+func create(tmpl, parent *x509.Certificate, key, parentKey *ecdsa.PrivateKey) []byte {
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return der
+}
+
+// write 把证书写到name.pem，私钥写到name-key.pem
+func write(dir, name string, der []byte, key *ecdsa.PrivateKey) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writePEM(filepath.Join(dir, name+".pem"), "CERTIFICATE", der, 0o644)
+	writePEM(filepath.Join(dir, name+"-key.pem"), "EC PRIVATE KEY", keyDER, 0o600)
+}
+
+func writePEM(path, typ string, der []byte, perm os.FileMode) {
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(path, data, perm); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,108 @@
+package proto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// 帧格式：varint编码的payload长度 + 1字节消息类型 + payload
+// TCP是字节流，一次Read可能只读到半个消息，也可能读到多个消息，
+// 所以必须按长度把消息切分出来
+
+// Type 是消息类型
+type Type byte
+
+const (
+	Text Type = iota + 1 // 文本消息
+	Ping                 // 心跳请求，收到后要回复Pong
+	Pong                 // 心跳回复
+)
+
+// MaxPayload 是允许的最大payload长度，防止对端发送超大长度耗尽内存
+const MaxPayload = 1 << 20
+
+var ErrTooLarge = errors.New("proto: message too large")
+
+type Message struct {
+	Type    Type
+	Payload []byte
+}
+
+// Encoder 可以被多个goroutine同时使用，每个帧都会完整地写出
+type Encoder struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode 把整个帧拼好后一次写出
+func (e *Encoder) Encode(m Message) error {
+	if len(m.Payload) > MaxPayload {
+		return ErrTooLarge
+	}
+	buf := make([]byte, binary.MaxVarintLen64+1+len(m.Payload))
+	n := binary.PutUvarint(buf, uint64(len(m.Payload)))
+	buf[n] = byte(m.Type)
+	n++
+	n += copy(buf[n:], m.Payload)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.w.Write(buf[:n])
+	return err
+}
+
+type Decoder struct {
+	r *bufio.Reader
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode 读取一个完整的消息。连接在两个消息之间关闭时返回io.EOF，
+// 在消息中间关闭时返回io.ErrUnexpectedEOF
+func (d *Decoder) Decode() (Message, error) {
+	size, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return Message{}, err
+	}
+	if size > MaxPayload {
+		return Message{}, ErrTooLarge
+	}
+	t, err := d.r.ReadByte()
+	if err != nil {
+		return Message{}, unexpected(err)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(d.r, payload); err != nil {
+		return Message{}, unexpected(err)
+	}
+	return Message{Type: Type(t), Payload: payload}, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (t Type) String() string {
+	switch t {
+	case Text:
+		return "text"
+	case Ping:
+		return "ping"
+	case Pong:
+		return "pong"
+	}
+	return fmt.Sprintf("type(%d)", byte(t))
+}
@@ -0,0 +1,155 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+var testMessages = []Message{
+	{Type: Text, Payload: []byte("你好")},
+	{Type: Ping, Payload: []byte{}},
+	{Type: Pong, Payload: []byte{}},
+	{Type: Text, Payload: bytes.Repeat([]byte("x"), 300)}, // 长度需要两个字节的varint
+	{Type: Text, Payload: bytes.Repeat([]byte("y"), MaxPayload)},
+}
+
+// encodeAll 把msgs依次编码到一个buffer中
+func encodeAll(t *testing.T, msgs []Message) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, m := range msgs {
+		if err := enc.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// decodeAll 从r中解码所有消息，直到io.EOF
+func decodeAll(t *testing.T, r io.Reader) []Message {
+	t.Helper()
+	dec := NewDecoder(r)
+	var msgs []Message
+	for {
+		m, err := dec.Decode()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("Decode() after %d messages: %v", len(msgs), err)
+		}
+		msgs = append(msgs, m)
+	}
+}
+
+// chunkReader 每次Read返回随机长度的数据，模拟TCP把写入拆开或合并
+type chunkReader struct {
+	data []byte
+	rnd  *rand.Rand
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := 1 + r.rnd.Intn(len(r.data))
+	if n > len(p) {
+		n = len(p)
+	}
+	n = copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestDecodeSplitAndMerged(t *testing.T) {
+	data := encodeAll(t, testMessages)
+	tests := []struct {
+		name string
+		r    io.Reader
+	}{
+		{"concatenated", bytes.NewReader(data)},
+		{"one byte at a time", iotest.OneByteReader(bytes.NewReader(data))},
+		{"half reads", iotest.HalfReader(bytes.NewReader(data))},
+		{"data with EOF", iotest.DataErrReader(bytes.NewReader(data))},
+		{"random chunks", &chunkReader{data: data, rnd: rand.New(rand.NewSource(1))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeAll(t, tt.r)
+			if !reflect.DeepEqual(got, testMessages) {
+				t.Fatalf("decoded %d messages, want %d equal to the encoded ones", len(got), len(testMessages))
+			}
+		})
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	frame := encodeAll(t, []Message{{Type: Text, Payload: bytes.Repeat([]byte("z"), 300)}})
+	// 截断在长度、类型和payload中间都应该返回io.ErrUnexpectedEOF
+	for _, n := range []int{1, 2, 3, len(frame) - 1} {
+		dec := NewDecoder(bytes.NewReader(frame[:n]))
+		if _, err := dec.Decode(); err != io.ErrUnexpectedEOF {
+			t.Errorf("Decode(frame[:%d]) error = %v, want %v", n, err, io.ErrUnexpectedEOF)
+		}
+	}
+
+	dec := NewDecoder(bytes.NewReader(nil))
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("Decode(empty) error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	enc := NewEncoder(io.Discard)
+	if err := enc.Encode(Message{Type: Text, Payload: make([]byte, MaxPayload+1)}); err != ErrTooLarge {
+		t.Errorf("Encode() error = %v, want %v", err, ErrTooLarge)
+	}
+
+	// 对端声明的长度超过MaxPayload时不会分配内存，直接返回ErrTooLarge
+	frame := binary.AppendUvarint(nil, 1<<40)
+	frame = append(frame, byte(Text))
+	dec := NewDecoder(bytes.NewReader(frame))
+	if _, err := dec.Decode(); err != ErrTooLarge {
+		t.Errorf("Decode() error = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestEncodeConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	const writers, each = 8, 100
+	done := make(chan struct{})
+	for w := 0; w < writers; w++ {
+		go func(w int) {
+			defer func() { done <- struct{}{} }()
+			payload := bytes.Repeat([]byte{byte('a' + w)}, 100+w)
+			for i := 0; i < each; i++ {
+				if err := enc.Encode(Message{Type: Text, Payload: payload}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	for w := 0; w < writers; w++ {
+		<-done
+	}
+
+	// 每个帧都应该完整，不会和其他goroutine的帧交错
+	msgs := decodeAll(t, &buf)
+	if len(msgs) != writers*each {
+		t.Fatalf("decoded %d messages, want %d", len(msgs), writers*each)
+	}
+	for _, m := range msgs {
+		w := int(m.Payload[0] - 'a')
+		if !bytes.Equal(m.Payload, bytes.Repeat([]byte{m.Payload[0]}, 100+w)) {
+			t.Fatalf("interleaved payload %q", m.Payload)
+		}
+	}
+}
@@ -1,37 +1,59 @@
 package main
 
 import (
-	"fmt"
-	"net"
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"gopl.io/demo/tcp/tlsconfig"
 )
 
 // 服务端侦听客服端连接
 func main() {
-	tcpAddr, _ := net.ResolveTCPAddr("tcp", ":8888")
-	listener, _ := net.ListenTCP("tcp", tcpAddr)
-	for {
-		conn, err := listener.AcceptTCP()
+	addr := flag.String("addr", ":8888", "listen address")
+	maxConns := flag.Int("max-conns", 100, "maximum concurrent connections (0 for unlimited)")
+	heartbeat := flag.Duration("heartbeat", 10*time.Second, "ping interval (0 disables heartbeats)")
+	certFile := flag.String("cert", "", "TLS certificate file (enables TLS)")
+	keyFile := flag.String("key", "", "TLS private key file")
+	clientCA := flag.String("client-ca", "", "CA file for verifying client certificates (enables mutual TLS)")
+	flag.Parse()
+
+	srv := &Server{
+		Addr:              *addr,
+		MaxConns:          *maxConns,
+		ReadTimeout:       3 * *heartbeat,
+		WriteTimeout:      5 * time.Second,
+		HeartbeatInterval: *heartbeat,
+	}
+	if *certFile != "" {
+		cfg, err := tlsconfig.Server(*certFile, *keyFile, *clientCA)
 		if err != nil {
-			fmt.Println(err)
-			return
+			log.Fatal(err)
 		}
-		go handleConnection(conn)
+		srv.TLSConfig = cfg
 	}
-}
 
-// 长连接侦听
-func handleConnection(conn *net.TCPConn) {
-	for {
-		b := make([]byte, 1024)
-		n, err := conn.Read(b)
-		if err != nil {
-			fmt.Println(err)
-			break
+	// 收到中断信号后优雅关闭
+	closed := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("event=shutdown err=%q", err)
 		}
-		fmt.Println(conn.RemoteAddr().String() + string(b[0:n]))
+		close(closed)
+	}()
 
-		// 向客户端返回信息
-		str := "服务端收到了：" + string(b[0:n])
-		conn.Write([]byte(str))
+	if err := srv.ListenAndServe(); err != ErrServerClosed {
+		log.Fatal(err)
 	}
+	<-closed
+	log.Printf("event=shutdown")
 }
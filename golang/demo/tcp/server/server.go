@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"gopl.io/demo/tcp/proto"
+)
+
+var ErrServerClosed = errors.New("tcp: server closed")
+
+// maxRejecting 限制同时在发送拒绝消息的连接数，超过时直接关闭，
+// 避免大量连接涌入时拒绝本身耗尽文件描述符
+const maxRejecting = 8
+
+// Server 是一个基于proto帧协议的TCP服务端
+type Server struct {
+	Addr              string
+	MaxConns          int           // 最大并发连接数，0表示不限制
+	ReadTimeout       time.Duration // 超过这个时间没有收到任何消息(包括Pong)就认为对端已死
+	WriteTimeout      time.Duration // 单个消息的写超时
+	HeartbeatInterval time.Duration // 发送Ping的间隔，0表示不发送
+	TLSConfig         *tls.Config   // 不为空时ListenAndServe使用TLS
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	sem      chan struct{}
+	rejects  chan struct{} // 正在reject的连接
+	wg       sync.WaitGroup
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+func (srv *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	if srv.TLSConfig != nil {
+		l = tls.NewListener(l, srv.TLSConfig)
+	}
+	return srv.Serve(l)
+}
+
+// Serve 在l上接受连接，直到Shutdown被调用或者l被关闭
+func (srv *Server) Serve(l net.Listener) error {
+	srv.mu.Lock()
+	select {
+	case <-srv.getQuitLocked():
+		srv.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	default:
+	}
+	srv.listener = l
+	srv.conns = make(map[net.Conn]struct{})
+	if srv.MaxConns > 0 {
+		srv.sem = make(chan struct{}, srv.MaxConns)
+		srv.rejects = make(chan struct{}, maxRejecting)
+	}
+	srv.mu.Unlock()
+	log.Printf("event=listen addr=%s max_conns=%d", l.Addr(), srv.MaxConns)
+
+	var delay time.Duration // Accept临时错误时的重试间隔
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if srv.shuttingDown() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			// 其他错误(EMFILE、ECONNABORTED等)都可能是暂时的，等一会儿再试
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			log.Printf("event=accept_error err=%q retry_in=%s", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		if srv.sem != nil {
+			select {
+			case srv.sem <- struct{}{}:
+			default:
+				select {
+				case srv.rejects <- struct{}{}:
+					log.Printf("event=reject remote=%s reason=max_conns", conn.RemoteAddr())
+					// TLS连接写之前要先握手，不能阻塞Accept
+					go func() {
+						srv.reject(conn)
+						<-srv.rejects
+					}()
+				default:
+					log.Printf("event=reject remote=%s reason=max_conns notified=false", conn.RemoteAddr())
+					conn.Close()
+				}
+				continue
+			}
+		}
+		if !srv.track(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go srv.handle(conn)
+	}
+}
+
+// Shutdown 停止接受新连接并通知所有连接退出，等待它们关闭。
+// ctx结束时强制关闭剩下的连接并返回ctx.Err()
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.quitOnce.Do(func() { close(srv.getQuitLocked()) })
+	if srv.listener != nil {
+		srv.listener.Close()
+	}
+	// 让阻塞在Decode上的连接立即返回
+	for c := range srv.conns {
+		c.SetReadDeadline(time.Now())
+	}
+	srv.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		srv.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		srv.mu.Lock()
+		for c := range srv.conns {
+			c.Close()
+		}
+		srv.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// getQuitLocked 需要持有srv.mu
+func (srv *Server) getQuitLocked() chan struct{} {
+	if srv.quit == nil {
+		srv.quit = make(chan struct{})
+	}
+	return srv.quit
+}
+
+func (srv *Server) shuttingDown() bool {
+	srv.mu.Lock()
+	quit := srv.getQuitLocked()
+	srv.mu.Unlock()
+	select {
+	case <-quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (srv *Server) track(c net.Conn) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	select {
+	case <-srv.getQuitLocked():
+		return false
+	default:
+	}
+	srv.conns[c] = struct{}{}
+	srv.wg.Add(1)
+	return true
+}
+
+func (srv *Server) untrack(c net.Conn) int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	delete(srv.conns, c)
+	return len(srv.conns)
+}
+
+func (srv *Server) active() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return len(srv.conns)
+}
+
+// reject 告诉客户端服务端已满然后关闭连接。
+// 用SetDeadline而不是SetWriteDeadline，TLS握手时的读也要有超时
+func (srv *Server) reject(c net.Conn) {
+	c.SetDeadline(time.Now().Add(time.Second))
+	proto.NewEncoder(c).Encode(proto.Message{Type: proto.Text, Payload: []byte("服务端连接数已满")})
+	c.Close()
+}
+
+// conn 是一个已接受的连接，写操作都经过send来设置写超时
+type conn struct {
+	srv *Server
+	c   net.Conn
+	enc *proto.Encoder
+}
+
+func (c *conn) send(m proto.Message) error {
+	if c.srv.WriteTimeout > 0 {
+		c.c.SetWriteDeadline(time.Now().Add(c.srv.WriteTimeout))
+	}
+	return c.enc.Encode(m)
+}
+
+func (srv *Server) handle(nc net.Conn) {
+	start := time.Now()
+	remote := nc.RemoteAddr().String()
+
+	var err error
+	if tc, ok := nc.(*tls.Conn); ok {
+		err = srv.handshake(tc)
+	}
+	if err == nil {
+		log.Printf("event=connect remote=%s active=%d%s", remote, srv.active(), peer(nc))
+		c := &conn{srv: srv, c: nc, enc: proto.NewEncoder(nc)}
+		stop := make(chan struct{})
+		if srv.HeartbeatInterval > 0 {
+			go c.heartbeat(stop)
+		}
+		err = c.serve()
+		close(stop)
+	}
+	nc.Close()
+
+	if srv.sem != nil {
+		<-srv.sem
+	}
+	active := srv.untrack(nc)
+	srv.wg.Done()
+	log.Printf("event=disconnect remote=%s duration=%s active=%d reason=%q",
+		remote, time.Since(start).Round(time.Millisecond), active, reason(err))
+}
+
+// handshake 在连接开始时完成TLS握手，握手失败的连接不会进入serve
+func (srv *Server) handshake(tc *tls.Conn) error {
+	timeout := srv.ReadTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	tc.SetDeadline(time.Now().Add(timeout))
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	return tc.SetDeadline(time.Time{})
+}
+
+// peer 返回日志中TLS对端的信息，双向认证时包含客户端证书的CN
+func peer(nc net.Conn) string {
+	tc, ok := nc.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	state := tc.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return " tls=true"
+	}
+	return fmt.Sprintf(" tls=true client_cn=%q", state.PeerCertificates[0].Subject.CommonName)
+}
+
+// serve 处理连接上的消息，返回断开的原因
+func (c *conn) serve() error {
+	dec := proto.NewDecoder(c.c)
+	for {
+		if c.srv.ReadTimeout > 0 {
+			c.c.SetReadDeadline(time.Now().Add(c.srv.ReadTimeout))
+		}
+		// 在设置超时之后检查，避免覆盖掉Shutdown设置的超时
+		if c.srv.shuttingDown() {
+			return ErrServerClosed
+		}
+		m, err := dec.Decode()
+		if err != nil {
+			if c.srv.shuttingDown() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		switch m.Type {
+		case proto.Ping:
+			err = c.send(proto.Message{Type: proto.Pong})
+		case proto.Pong:
+			// 收到任何消息都已经刷新了读超时
+		case proto.Text:
+			log.Printf("event=message remote=%s bytes=%d", c.c.RemoteAddr(), len(m.Payload))
+			// 向客户端返回信息
+			str := "服务端收到了：" + string(m.Payload)
+			err = c.send(proto.Message{Type: proto.Text, Payload: []byte(str)})
+		default:
+			log.Printf("event=unknown_message remote=%s type=%s", c.c.RemoteAddr(), m.Type)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// heartbeat 定时发送Ping，对端没有回应时读超时会让serve返回
+func (c *conn) heartbeat(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.srv.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.send(proto.Message{Type: proto.Ping}); err != nil {
+				// 写失败时关闭连接让serve退出
+				c.c.Close()
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func reason(err error) string {
+	var ne net.Error
+	switch {
+	case err == io.EOF:
+		return "closed by peer"
+	case err == ErrServerClosed:
+		return "server shutdown"
+	case errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	}
+	return err.Error()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"gopl.io/demo/tcp/proto"
+)
+
+// pipeListener 是用net.Pipe代替TCP的Listener，dial返回客户端一端
+type pipeListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+func (l *pipeListener) dial(t *testing.T) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+	case <-time.After(time.Second):
+		t.Fatal("server is not accepting")
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// startServer 在pipeListener上运行srv，测试结束时关闭
+func startServer(t *testing.T, srv *Server) *pipeListener {
+	t.Helper()
+	l := newPipeListener()
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		<-served
+	})
+	return l
+}
+
+func TestRejectBounded(t *testing.T) {
+	srv := &Server{MaxConns: 1}
+	l := startServer(t, srv)
+	l.dial(t) // 占满MaxConns
+
+	// 这些连接不读数据，reject会一直阻塞在写上直到超时
+	for i := 0; i < maxRejecting; i++ {
+		l.dial(t)
+	}
+	// 正在reject的连接已经达到上限，新连接直接关闭
+	c := l.dial(t)
+	c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := c.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("Read() error = %v, want the connection closed immediately", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func TestRejectMessage(t *testing.T) {
+	srv := &Server{MaxConns: 1}
+	l := startServer(t, srv)
+	l.dial(t)
+
+	c := l.dial(t)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	dec := proto.NewDecoder(c)
+	m, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != proto.Text || string(m.Payload) != "服务端连接数已满" {
+		t.Errorf("got %s %q, want the rejection message", m.Type, m.Payload)
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("Decode() after rejection error = %v, want %v", err, io.EOF)
+	}
+}
+
+// echo 发送一条Text并读取回复，确认连接已经被serve处理
+func echo(t *testing.T, c net.Conn) {
+	t.Helper()
+	c.SetDeadline(time.Now().Add(time.Second))
+	if err := proto.NewEncoder(c).Encode(proto.Message{Type: proto.Text, Payload: []byte("hi")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proto.NewDecoder(c).Decode(); err != nil {
+		t.Fatal(err)
+	}
+	c.SetDeadline(time.Time{})
+}
+
+func TestShutdownDrains(t *testing.T) {
+	srv := &Server{}
+	l := startServer(t, srv)
+	c := l.dial(t)
+	echo(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v, want nil", err)
+	}
+	if n := srv.active(); n != 0 {
+		t.Errorf("%d connections still active", n)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read() after Shutdown error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestShutdownForceClose(t *testing.T) {
+	srv := &Server{}
+	l := startServer(t, srv)
+	c := l.dial(t)
+	// 不读取回复，serve阻塞在写上，读超时不能让它退出
+	if err := proto.NewEncoder(c).Encode(proto.Message{Type: proto.Text, Payload: []byte("hi")}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// 连接被强制关闭，handle随后退出
+	deadline := time.Now().Add(time.Second)
+	for srv.active() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("connection was not closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHeartbeatTimeout(t *testing.T) {
+	srv := &Server{HeartbeatInterval: 20 * time.Millisecond, ReadTimeout: 100 * time.Millisecond}
+	l := startServer(t, srv)
+	c := l.dial(t)
+
+	// 只读不回Pong，服务端应该在ReadTimeout之后断开
+	start := time.Now()
+	c.SetReadDeadline(start.Add(2 * time.Second))
+	dec := proto.NewDecoder(c)
+	pings := 0
+	for {
+		m, err := dec.Decode()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatalf("Decode() error = %v, want %v", err, io.EOF)
+			}
+			break
+		}
+		if m.Type == proto.Ping {
+			pings++
+		}
+	}
+	if pings == 0 {
+		t.Error("no Ping received")
+	}
+	if d := time.Since(start); d < srv.ReadTimeout {
+		t.Errorf("dropped after %v, before ReadTimeout", d)
+	}
+}
+
+func TestHeartbeatPong(t *testing.T) {
+	srv := &Server{HeartbeatInterval: 20 * time.Millisecond, ReadTimeout: 100 * time.Millisecond}
+	l := startServer(t, srv)
+	c := l.dial(t)
+
+	// 每个Ping都回Pong，超过几倍ReadTimeout之后连接仍然可用
+	enc, dec := proto.NewEncoder(c), proto.NewDecoder(c)
+	for stop := time.Now().Add(3 * srv.ReadTimeout); time.Now().Before(stop); {
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		m, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Type == proto.Ping {
+			if err := enc.Encode(proto.Message{Type: proto.Pong}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
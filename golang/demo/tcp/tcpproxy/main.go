@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// tcpproxy 把-listen上收到的连接转发到-target，可以注入延迟和断线来测试客户端
+var (
+	listen   = flag.String("listen", ":9999", "listen address")
+	target   = flag.String("target", "localhost:8888", "target address")
+	latency  = flag.Duration("latency", 0, "delay added before forwarding each chunk")
+	jitter   = flag.Duration("jitter", 0, "random extra delay up to this value")
+	dropConn = flag.Float64("drop-conn", 0, "probability of refusing a new connection")
+	drop     = flag.Float64("drop", 0, "probability of cutting a connection at each forwarded chunk")
+)
+
+var errDropped = errors.New("dropped by proxy")
+
+// 所有连接的累计字节数
+var totalUp, totalDown int64
+
+func main() {
+	flag.Parse()
+	laddr, err := net.ResolveTCPAddr("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	taddr, err := net.ResolveTCPAddr("tcp", *target)
+	if err != nil {
+		log.Fatal(err)
+	}
+	listener, err := net.ListenTCP("tcp", laddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("event=listen addr=%s target=%s", listener.Addr(), taddr)
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Printf("event=exit up=%d down=%d", atomic.LoadInt64(&totalUp), atomic.LoadInt64(&totalDown))
+		os.Exit(0)
+	}()
+
+	var id int64
+	for {
+		conn, err := listener.AcceptTCP()
+		if err != nil {
+			log.Print(err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		id++
+		if rand.Float64() < *dropConn {
+			log.Printf("event=refuse conn=%d client=%s", id, conn.RemoteAddr())
+			conn.SetLinger(0) // 直接发送RST
+			conn.Close()
+			continue
+		}
+		go proxy(id, conn, taddr)
+	}
+}
+
+func proxy(id int64, client *net.TCPConn, taddr *net.TCPAddr) {
+	start := time.Now()
+	defer client.Close()
+	server, err := net.DialTCP("tcp", nil, taddr)
+	if err != nil {
+		log.Printf("event=dial_error conn=%d err=%q", id, err)
+		return
+	}
+	defer server.Close()
+	log.Printf("event=connect conn=%d client=%s", id, client.RemoteAddr())
+
+	var up, down int64
+	var upErr, downErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		up, upErr = pipe(server, client)
+	}()
+	go func() {
+		defer wg.Done()
+		down, downErr = pipe(client, server)
+	}()
+	wg.Wait()
+
+	atomic.AddInt64(&totalUp, up)
+	atomic.AddInt64(&totalDown, down)
+	log.Printf("event=disconnect conn=%d up=%d down=%d duration=%s up_err=%q down_err=%q",
+		id, up, down, time.Since(start).Round(time.Millisecond), errString(upErr), errString(downErr))
+}
+
+// pipe 把src复制到dst。src读到EOF时关闭dst的写端，把半关闭传给另一端；
+// 出错时直接关闭两个连接，让另一个方向的复制也结束
+func pipe(dst, src *net.TCPConn) (int64, error) {
+	var w io.Writer = dst
+	if *latency > 0 || *jitter > 0 || *drop > 0 {
+		w = &faultWriter{w: dst}
+	}
+	n, err := io.Copy(w, src)
+	if err != nil {
+		if errors.Is(err, errDropped) {
+			// 模拟网络故障，两端都收到RST
+			dst.SetLinger(0)
+			src.SetLinger(0)
+		}
+		dst.Close()
+		src.Close()
+		return n, err
+	}
+	dst.CloseWrite()
+	return n, nil
+}
+
+// faultWriter 在每次写之前加入延迟，并按概率中断连接
+type faultWriter struct {
+	w io.Writer
+}
+
+func (f *faultWriter) Write(p []byte) (int, error) {
+	if rand.Float64() < *drop {
+		return 0, errDropped
+	}
+	delay := *latency
+	if *jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(*jitter)))
+	}
+	time.Sleep(delay)
+	return f.w.Write(p)
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -1,36 +1,20 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
-	"github.com/gorilla/websocket"
+	"flag"
 	"log"
 	"os"
+
+	"gopl.io/demo/websocket/wsclient"
 )
 
 func main() {
-	dl := websocket.Dialer{}
-	conn, _, err := dl.Dial("ws://127.0.0.1:8090", nil)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	go send(conn)
-
-	for {
-		m, p, e := conn.ReadMessage()
-		if e != nil {
-			break
-		}
-		fmt.Println(m, string(p))
-	}
-
-}
+	addr := flag.String("addr", "127.0.0.1:8090", "server address")
+	room := flag.String("room", "lobby", "room to join")
+	token := flag.String("token", "", "JWT issued by demo/jwt-use")
+	flag.Parse()
 
-func send(conn *websocket.Conn) {
-	for {
-		r := bufio.NewReader(os.Stdin)
-		l, _, _ := r.ReadLine()
-		conn.WriteMessage(websocket.TextMessage, l)
+	if err := wsclient.Chat(*addr, *room, *token, os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
 	}
 }
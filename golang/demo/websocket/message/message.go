@@ -0,0 +1,34 @@
+package message
+
+import "time"
+
+// Type 是消息的类型
+type Type string
+
+const (
+	TypeJoin    Type = "join"    // 加入房间
+	TypeLeave   Type = "leave"   // 离开房间
+	TypeMessage Type = "message" // 房间内的聊天消息
+	TypeTyping  Type = "typing"  // 正在输入
+	TypeError   Type = "error"   // 服务端返回给发送者的错误
+	TypeHistory Type = "history" // 加入房间时补发的历史消息
+)
+
+// Envelope 是客户端和服务端之间传递的JSON消息
+type Envelope struct {
+	Type Type   `json:"type"`
+	Room string `json:"room,omitempty"`
+	From string `json:"from,omitempty"`
+	Body string `json:"body,omitempty"`
+	TS   int64  `json:"ts,omitempty"` // 服务端收到消息的时间，Unix毫秒
+
+	// Seq 是服务端给每条保存的message分配的递增序号。
+	// 客户端join时带上自己见过的最大Seq，服务端会在history中补发之后的消息
+	Seq     uint64     `json:"seq,omitempty"`
+	History []Envelope `json:"history,omitempty"`
+}
+
+// Now 返回当前时间对应的TS
+func Now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"gopl.io/demo/jwt-use/token"
+)
+
+// 客户端通过请求头 Sec-WebSocket-Protocol: jwt, <token> 或者查询参数 ?token=<token> 传递token。
+// 浏览器的WebSocket不能设置其他请求头，所以借用子协议字段
+const tokenProtocol = "jwt"
+
+func tokenFromRequest(req *http.Request) string {
+	if t := req.URL.Query().Get("token"); t != "" {
+		return t
+	}
+	protocols := websocket.Subprotocols(req)
+	for i, p := range protocols {
+		if p == tokenProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+// authenticate 在升级之前校验token，失败时返回401
+func authenticate(rep http.ResponseWriter, req *http.Request, key []byte) (*token.MyClaims, bool) {
+	t := tokenFromRequest(req)
+	if t == "" {
+		http.Error(rep, "missing token", http.StatusUnauthorized)
+		return nil, false
+	}
+	claims, err := token.Parse(t, key)
+	if err != nil {
+		http.Error(rep, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	return claims, true
+}
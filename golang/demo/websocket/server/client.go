@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopl.io/demo/websocket/message"
+)
+
+// Client 是hub和一个websocket连接之间的中间层。
+// 读和写分别在readPump和writePump两个goroutine中进行，每个连接同时只有一个写者
+type Client struct {
+	hub   *Hub
+	conn  *websocket.Conn
+	send  chan []byte
+	name  string          // token中的用户名，作为消息的发送者
+	drops int             // DropOldest时丢弃的消息数，只由hub读写
+	rooms map[string]bool // 已加入的房间，只由hub读写
+}
+
+// readPump 把连接上收到的消息交给hub广播，出错时注销客户端
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister <- c
+		c.conn.Close()
+	}()
+	cfg := c.hub.cfg
+	// 限制单条消息的大小，超过时ReadMessage返回错误并关闭连接
+	c.conn.SetReadLimit(cfg.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(cfg.PongWait))
+	// 收到pong说明对端还活着，延长读超时
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(cfg.PongWait))
+	})
+	for {
+		_, p, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println(err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(cfg.PongWait))
+		env := new(message.Envelope)
+		if err := json.Unmarshal(p, env); err != nil {
+			env = &message.Envelope{Type: message.TypeError, Body: "invalid message: " + err.Error(), TS: message.Now()}
+			c.hub.incoming <- inbound{c, env}
+			continue
+		}
+		if env.Type == message.TypeError {
+			// 客户端不能发送error类型
+			env.Type = ""
+		}
+		// 发送者、时间和历史消息由服务端填写，客户端不能伪造。
+		// Seq只在join时表示客户端见过的最大序号
+		env.From = c.name
+		env.TS = message.Now()
+		env.History = nil
+		if env.Type != message.TypeJoin {
+			env.Seq = 0
+		}
+		c.hub.incoming <- inbound{c, env}
+	}
+}
+
+// writePump 把发送队列里的消息写到连接并定时发送ping，
+// 队列被hub关闭后发送关闭帧并退出
+func (c *Client) writePump() {
+	cfg := c.hub.cfg
+	ticker := time.NewTicker(cfg.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(cfg.WriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				c.fail()
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(cfg.WriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.fail()
+				return
+			}
+		}
+	}
+}
+
+// fail 在写出错后关闭连接让readPump出错并注销，然后取出消息直到hub关闭队列
+func (c *Client) fail() {
+	c.conn.Close()
+	for range c.send {
+	}
+}
+
+func serveWs(hub *Hub, rep http.ResponseWriter, req *http.Request) {
+	claims, ok := authenticate(rep, req, hub.cfg.JWTKey)
+	if !ok {
+		return
+	}
+	conn, err := UP.Upgrade(rep, req, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	c := &Client{
+		hub:   hub,
+		conn:  conn,
+		send:  make(chan []byte, hub.cfg.SendQueue),
+		name:  claims.Username,
+		rooms: make(map[string]bool),
+	}
+	hub.register <- c
+
+	go c.writePump()
+	go c.readPump()
+}
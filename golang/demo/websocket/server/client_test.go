@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopl.io/demo/websocket/message"
+)
+
+// newStalledClient 返回一个已加入room的客户端，没有writePump读取它的发送队列
+func newStalledClient(h *Hub, name, room string) *Client {
+	c := &Client{
+		hub:   h,
+		send:  make(chan []byte, h.cfg.SendQueue),
+		name:  name,
+		rooms: map[string]bool{room: true},
+	}
+	h.clients[c] = true
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[*Client]bool)
+	}
+	h.rooms[room][c] = true
+	return c
+}
+
+func TestSlowDisconnect(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.SendQueue = 4
+	cfg.Slow = Disconnect
+	h := newHub(cfg, nil) // 不运行run，直接在测试goroutine中调用hub的方法
+	slow := newStalledClient(h, "slow", "go")
+	peer := newStalledClient(h, "peer", "go")
+
+	for i := 0; i < cfg.SendQueue; i++ {
+		h.sendRaw(slow, []byte("x"))
+	}
+	if !h.clients[slow] {
+		t.Fatal("client removed before its queue was full")
+	}
+	h.sendRaw(slow, []byte("x"))
+
+	if h.clients[slow] || h.rooms["go"][slow] {
+		t.Error("slow client still registered after queue overflow")
+	}
+	// 队列被关闭：取出已有的消息之后读到关闭
+	for range slow.send {
+	}
+	// 房间里的其他人收到离开通知
+	if n := len(peer.send); n != 1 {
+		t.Fatalf("peer has %d queued messages, want 1 leave", n)
+	}
+	if got := string(<-peer.send); !strings.Contains(got, `"from":"slow"`) {
+		t.Errorf("peer got %s, want leave from slow", got)
+	}
+}
+
+func TestSlowDropOldest(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.SendQueue = 4
+	cfg.Slow = DropOldest
+	h := newHub(cfg, nil)
+	slow := newStalledClient(h, "slow", "go")
+
+	const total = 10
+	for i := 0; i < total; i++ {
+		h.sendRaw(slow, []byte(fmt.Sprint(i)))
+	}
+	if !h.clients[slow] {
+		t.Fatal("DropOldest removed the client")
+	}
+	if slow.drops != total-cfg.SendQueue {
+		t.Errorf("drops = %d, want %d", slow.drops, total-cfg.SendQueue)
+	}
+	// 队列里保留最新的消息
+	for i := total - cfg.SendQueue; i < total; i++ {
+		if got, want := string(<-slow.send), fmt.Sprint(i); got != want {
+			t.Errorf("queued %s, want %s", got, want)
+		}
+	}
+}
+
+func TestOversizeMessageCloses(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.MaxMessageSize = 64
+	url := newTestServer(t, cfg)
+	conn := dial(t, url, "alice")
+
+	send(t, conn, message.Envelope{Type: message.TypeMessage, Room: "go", Body: strings.Repeat("x", 128)})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var env message.Envelope
+		err := conn.ReadJSON(&env)
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+			t.Fatalf("read error = %v, want close %d", err, websocket.CloseMessageTooBig)
+		}
+		return
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// SlowPolicy 决定客户端发送队列满了之后怎么处理
+type SlowPolicy int
+
+const (
+	Disconnect SlowPolicy = iota // 断开慢客户端
+	DropOldest                   // 丢弃队列里最旧的消息，保留最新的
+)
+
+func (p SlowPolicy) String() string {
+	switch p {
+	case Disconnect:
+		return "disconnect"
+	case DropOldest:
+		return "drop-oldest"
+	}
+	return fmt.Sprintf("SlowPolicy(%d)", int(p))
+}
+
+// Set 实现flag.Value
+func (p *SlowPolicy) Set(s string) error {
+	switch s {
+	case "disconnect":
+		*p = Disconnect
+	case "drop-oldest":
+		*p = DropOldest
+	default:
+		return fmt.Errorf("unknown slow consumer policy %q", s)
+	}
+	return nil
+}
+
+// Config 是连接相关的配置
+type Config struct {
+	PingInterval   time.Duration // 发送ping的间隔，必须小于PongWait
+	PongWait       time.Duration // 超过这个时间没有收到任何数据(包括pong)就断开
+	WriteWait      time.Duration // 单条消息的写超时
+	MaxMessageSize int64         // 客户端单条消息的最大字节数
+	SendQueue      int           // 每个连接最多缓存的待发送消息数
+	Slow           SlowPolicy
+	JWTKey         []byte // 校验客户端token签名的key
+	ReplayLimit    int    // 加入房间时最多补发的历史消息数
+}
+
+var DefaultConfig = Config{
+	PingInterval:   50 * time.Second,
+	PongWait:       60 * time.Second,
+	WriteWait:      10 * time.Second,
+	MaxMessageSize: 64 << 10,
+	SendQueue:      16,
+	Slow:           Disconnect,
+	ReplayLimit:    100,
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"gopl.io/demo/websocket/message"
+)
+
+// History 把聊天消息追加保存到文件中，每行一个JSON，
+// 并在内存中按房间保留最新的limit条用于补发。只在hub的goroutine中使用
+type History struct {
+	f     *os.File
+	seq   uint64
+	limit int // 每个房间在内存中保留的消息数，0表示不限制
+	rooms map[string][]message.Envelope
+}
+
+// openHistory 打开(或创建)path，读入已有的消息。
+// 文件末尾写了一半的记录会被截掉，保证之后追加的记录从新的一行开始
+func openHistory(path string, limit int) (*History, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	h := &History{f: f, limit: limit, rooms: make(map[string][]message.Envelope)}
+	// 单条记录的长度取决于-max-message，这里不限制行长，
+	// 否则调大-max-message之后服务端会无法启动
+	r := bufio.NewReader(f)
+	var off, good int64 // good是最后一条完整记录结束的位置
+	for {
+		line, err := r.ReadBytes('\n')
+		off += int64(len(line))
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var env message.Envelope
+			if err := json.Unmarshal(line, &env); err != nil || env.Seq <= h.seq {
+				log.Printf("history: skipping bad record: %q", line)
+			} else {
+				h.seq = env.Seq
+				h.add(env)
+				good = off
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if off > good {
+		// 程序崩溃时最后一行可能只写了一半
+		log.Printf("history: truncating %d bytes after the last complete record", off-good)
+		if err := f.Truncate(good); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Append 给env分配序号并写入文件
+func (h *History) Append(env *message.Envelope) error {
+	env.Seq = h.seq + 1
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if _, err := h.f.Write(append(data, '\n')); err != nil {
+		env.Seq = 0
+		return err
+	}
+	h.seq = env.Seq
+	h.add(*env)
+	return nil
+}
+
+// add 把env放进内存中它的房间，超过limit的旧消息会被丢掉
+func (h *History) add(env message.Envelope) {
+	msgs := append(h.rooms[env.Room], env)
+	// 超过两倍时才复制，避免每条消息都复制一次
+	if h.limit > 0 && len(msgs) > 2*h.limit {
+		msgs = append([]message.Envelope(nil), msgs[len(msgs)-h.limit:]...)
+	}
+	h.rooms[env.Room] = msgs
+}
+
+// Since 返回room中序号大于seq的消息，最多返回最新的limit条
+func (h *History) Since(room string, seq uint64, limit int) []message.Envelope {
+	msgs := h.rooms[room]
+	i := sort.Search(len(msgs), func(i int) bool { return msgs[i].Seq > seq })
+	msgs = msgs[i:]
+	if limit > 0 && len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	return msgs
+}
+
+func (h *History) Close() error {
+	return h.f.Close()
+}
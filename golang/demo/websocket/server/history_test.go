@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopl.io/demo/websocket/message"
+)
+
+func TestHistoryLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h, err := openHistory(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		env := &message.Envelope{Type: message.TypeMessage, Room: "lobby", Body: "hi"}
+		if err := h.Append(env); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(h.rooms["lobby"]); n > 6 {
+		t.Errorf("%d messages kept in memory, want at most 6", n)
+	}
+	msgs := h.Since("lobby", 0, 3)
+	if len(msgs) != 3 || msgs[0].Seq != 8 || msgs[2].Seq != 10 {
+		t.Errorf("Since(0) = %v, want seq 8..10", msgs)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开时也只保留最新的
+	h, err = openHistory(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if n := len(h.rooms["lobby"]); n > 6 {
+		t.Errorf("%d messages kept after reopening, want at most 6", n)
+	}
+	if msgs := h.Since("lobby", 8, 3); len(msgs) != 2 || msgs[0].Seq != 9 {
+		t.Errorf("Since(8) = %v, want seq 9..10", msgs)
+	}
+}
+
+func TestHistoryTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h, err := openHistory(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Append(&message.Envelope{Type: message.TypeMessage, Room: "lobby", Body: "one"})
+	h.Close()
+	// 模拟写到一半时崩溃
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"type":"message","room":"lob`)
+	f.Close()
+
+	h, err = openHistory(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Append(&message.Envelope{Type: message.TypeMessage, Room: "lobby", Body: "two"}); err != nil {
+		t.Fatal(err)
+	}
+	h.Close()
+
+	// 新记录不会接在半条记录后面，重启后两条都在
+	h, err = openHistory(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	msgs := h.Since("lobby", 0, 0)
+	if len(msgs) != 2 || msgs[0].Body != "one" || msgs[1].Body != "two" || msgs[1].Seq != 2 {
+		t.Errorf("Since(0) = %+v, want one and two", msgs)
+	}
+}
+
+func TestHistoryLongRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h, err := openHistory(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 比bufio.Scanner默认的上限大得多的消息
+	body := strings.Repeat("x", 2<<20)
+	h.Append(&message.Envelope{Type: message.TypeMessage, Room: "lobby", Body: body})
+	h.Close()
+
+	h, err = openHistory(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if msgs := h.Since("lobby", 0, 0); len(msgs) != 1 || msgs[0].Body != body {
+		t.Errorf("long record was not loaded")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"gopl.io/demo/websocket/message"
+)
+
+// inbound 是客户端发给hub的消息
+type inbound struct {
+	c   *Client
+	env *message.Envelope
+}
+
+// Hub 维护所有在线的连接和房间，并把消息广播给房间内的连接。
+// clients、rooms以及Client.rooms只在run所在的goroutine中读写，不需要加锁
+type Hub struct {
+	cfg        Config
+	history    *History // 为nil时不保存历史消息
+	clients    map[*Client]bool
+	rooms      map[string]map[*Client]bool
+	register   chan *Client
+	unregister chan *Client
+	incoming   chan inbound
+}
+
+func newHub(cfg Config, history *History) *Hub {
+	return &Hub{
+		cfg:        cfg,
+		history:    history,
+		clients:    make(map[*Client]bool),
+		rooms:      make(map[string]map[*Client]bool),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		incoming:   make(chan inbound),
+	}
+}
+
+func (h *Hub) run() {
+	for {
+		select {
+		case c := <-h.register:
+			h.clients[c] = true
+		case c := <-h.unregister:
+			h.remove(c)
+		case in := <-h.incoming:
+			h.handle(in.c, in.env)
+		}
+	}
+}
+
+func (h *Hub) handle(c *Client, env *message.Envelope) {
+	if !h.clients[c] {
+		return
+	}
+	switch env.Type {
+	case message.TypeJoin:
+		if env.Room == "" {
+			h.reply(c, "room is required")
+			return
+		}
+		if c.rooms[env.Room] {
+			return
+		}
+		if h.rooms[env.Room] == nil {
+			h.rooms[env.Room] = make(map[*Client]bool)
+		}
+		h.rooms[env.Room][c] = true
+		c.rooms[env.Room] = true
+		// 先补发客户端错过的消息，之后才是实时消息
+		h.replay(c, env.Room, env.Seq)
+		env.Seq = 0
+		h.broadcast(env)
+	case message.TypeLeave:
+		if c.rooms[env.Room] {
+			h.broadcast(env)
+			h.leave(c, env.Room)
+		}
+	case message.TypeMessage, message.TypeTyping:
+		if !c.rooms[env.Room] {
+			h.reply(c, "not in room "+env.Room)
+			return
+		}
+		env.Seq = 0
+		if env.Type == message.TypeMessage && h.history != nil {
+			if err := h.history.Append(env); err != nil {
+				log.Println("history:", err)
+			}
+		}
+		h.broadcast(env)
+	case message.TypeError:
+		// readPump解析失败时生成的错误，只发回给发送者
+		h.send(c, env)
+	default:
+		h.reply(c, "unknown message type "+string(env.Type))
+	}
+}
+
+// replay 把room中序号大于seq的历史消息放在一个history消息里发给c，
+// Seq是当前最新的序号，客户端之后可以用它来续传
+func (h *Hub) replay(c *Client, room string, seq uint64) {
+	if h.history == nil {
+		return
+	}
+	h.send(c, &message.Envelope{
+		Type:    message.TypeHistory,
+		Room:    room,
+		TS:      message.Now(),
+		Seq:     h.history.seq,
+		History: h.history.Since(room, seq, h.cfg.ReplayLimit),
+	})
+}
+
+// broadcast 把消息发给env.Room里的所有连接
+func (h *Hub) broadcast(env *message.Envelope) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for c := range h.rooms[env.Room] {
+		h.sendRaw(c, data)
+	}
+}
+
+func (h *Hub) reply(c *Client, body string) {
+	h.send(c, &message.Envelope{Type: message.TypeError, Body: body, TS: message.Now()})
+}
+
+func (h *Hub) send(c *Client, env *message.Envelope) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	h.sendRaw(c, data)
+}
+
+// sendRaw 不会阻塞hub。发送队列满了说明客户端读得太慢，按h.cfg.Slow处理
+func (h *Hub) sendRaw(c *Client, data []byte) {
+	select {
+	case c.send <- data:
+		return
+	default:
+	}
+	if h.cfg.Slow == Disconnect {
+		log.Printf("%s: send queue full, disconnecting", c.name)
+		h.remove(c)
+		return
+	}
+	// 只有hub往队列里放消息，取出一条之后一定有空位
+	select {
+	case <-c.send:
+		c.drops++
+		if c.drops == 1 || c.drops%100 == 0 {
+			log.Printf("%s: send queue full, %d messages dropped", c.name, c.drops)
+		}
+	default:
+	}
+	c.send <- data
+}
+
+func (h *Hub) leave(c *Client, room string) {
+	delete(c.rooms, room)
+	delete(h.rooms[room], c)
+	if len(h.rooms[room]) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// remove 把客户端从所有房间移除并通知房间里的其他人，
+// 然后关闭它的发送队列，writePump随后会关闭连接
+func (h *Hub) remove(c *Client) {
+	if !h.clients[c] {
+		return
+	}
+	delete(h.clients, c)
+	close(c.send)
+	for room := range c.rooms {
+		h.leave(c, room)
+		h.broadcast(&message.Envelope{Type: message.TypeLeave, Room: room, From: c.name, TS: message.Now()})
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/websocket"
+	"gopl.io/demo/jwt-use/token"
+	"gopl.io/demo/websocket/message"
+)
+
+var testKey = []byte("test key")
+
+// newTestServer 启动hub和一个httptest服务端，返回ws://地址
+func newTestServer(t *testing.T, cfg Config) string {
+	t.Helper()
+	return newHistoryServer(t, cfg, nil)
+}
+
+// newHistoryServer 和newTestServer一样，但hub使用history保存消息
+func newHistoryServer(t *testing.T, cfg Config, history *History) string {
+	t.Helper()
+	cfg.JWTKey = testKey
+	hub := newHub(cfg, history)
+	go hub.run()
+	srv := httptest.NewServer(http.HandlerFunc(func(rep http.ResponseWriter, req *http.Request) {
+		serveWs(hub, rep, req)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func testToken(t *testing.T, username string) string {
+	t.Helper()
+	return signToken(t, testKey, token.MyClaims{
+		Username: username,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	})
+}
+
+func signToken(t *testing.T, key []byte, c token.MyClaims) string {
+	t.Helper()
+	ss, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ss
+}
+
+// dial 用username的token连接服务端
+func dial(t *testing.T, url, username string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?token="+testToken(t, username), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, env message.Envelope) {
+	t.Helper()
+	if err := conn.WriteJSON(env); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expect 读取下一个typ类型的消息，跳过其他类型
+func expect(t *testing.T, conn *websocket.Conn, typ message.Type) message.Envelope {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var env message.Envelope
+		if err := conn.ReadJSON(&env); err != nil {
+			t.Fatalf("waiting for %s: %v", typ, err)
+		}
+		if env.Type == typ {
+			return env
+		}
+	}
+}
+
+// expectNothing 确认短时间内没有收到消息，之后conn不能再读
+func expectNothing(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	var env message.Envelope
+	if err := conn.ReadJSON(&env); err == nil {
+		t.Fatalf("unexpected message %+v", env)
+	}
+}
+
+// join 加入room，等到自己的join被广播回来，说明hub已经处理完
+func join(t *testing.T, conn *websocket.Conn, room string) {
+	t.Helper()
+	send(t, conn, message.Envelope{Type: message.TypeJoin, Room: room})
+	expect(t, conn, message.TypeJoin)
+}
+
+func TestHubUnauthorized(t *testing.T) {
+	url := newTestServer(t, DefaultConfig)
+	expired := signToken(t, testKey, token.MyClaims{
+		Username:       "alice",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(-time.Minute).Unix()},
+	})
+	wrongKey := signToken(t, []byte("wrong key"), token.MyClaims{
+		Username:       "alice",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	})
+	noExp := signToken(t, testKey, token.MyClaims{Username: "eve"})
+
+	tests := []struct {
+		name      string
+		query     string
+		protocols []string
+	}{
+		{"missing token", "", nil},
+		{"expired", "?token=" + expired, nil},
+		{"wrong key", "?token=" + wrongKey, nil},
+		{"no exp", "?token=" + noExp, nil},
+		{"tampered", "?token=" + testToken(t, "alice") + "x", nil},
+		{"subprotocol wrong key", "", []string{tokenProtocol, wrongKey}},
+		{"subprotocol without token", "", []string{tokenProtocol}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := websocket.Dialer{Subprotocols: tt.protocols}
+			conn, resp, err := d.Dial(url+tt.query, nil)
+			if err == nil {
+				conn.Close()
+				t.Fatal("Dial succeeded")
+			}
+			if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("response = %v, want 401", resp)
+			}
+		})
+	}
+}
+
+func TestHubSubprotocolToken(t *testing.T) {
+	url := newTestServer(t, DefaultConfig)
+	d := websocket.Dialer{Subprotocols: []string{tokenProtocol, testToken(t, "alice")}}
+	conn, _, err := d.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 服务端只能选择jwt子协议，不能把token原样回显
+	if p := conn.Subprotocol(); p != tokenProtocol {
+		t.Errorf("Subprotocol() = %q, want %q", p, tokenProtocol)
+	}
+	join(t, conn, "go")
+}
+
+func TestHubBroadcast(t *testing.T) {
+	url := newTestServer(t, DefaultConfig)
+	alice := dial(t, url, "alice")
+	bob := dial(t, url, "bob")
+	carol := dial(t, url, "carol")
+	join(t, alice, "go")
+	join(t, bob, "go")
+	join(t, carol, "rust")
+
+	// alice也会收到bob加入的消息
+	if env := expect(t, alice, message.TypeJoin); env.From != "bob" {
+		t.Errorf("join from %q, want bob", env.From)
+	}
+
+	// 不在房间里不能发消息
+	send(t, carol, message.Envelope{Type: message.TypeMessage, Room: "go", Body: "hi"})
+	if env := expect(t, carol, message.TypeError); !strings.Contains(env.Body, "not in room") {
+		t.Errorf("error = %q, want not in room", env.Body)
+	}
+
+	send(t, alice, message.Envelope{Type: message.TypeMessage, Room: "go", Body: "hello", From: "mallory"})
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		env := expect(t, conn, message.TypeMessage)
+		if env.Body != "hello" || env.From != "alice" || env.Room != "go" {
+			t.Errorf("got %+v, want hello from alice in go", env)
+		}
+	}
+	// 其他房间收不到。读超时之后连接不能再用，所以放在最后
+	expectNothing(t, carol)
+}
+
+func TestHubUnregisterOnClose(t *testing.T) {
+	url := newTestServer(t, DefaultConfig)
+	alice := dial(t, url, "alice")
+	bob := dial(t, url, "bob")
+	join(t, alice, "go")
+	join(t, bob, "go")
+	expect(t, alice, message.TypeJoin) // bob加入
+
+	bob.Close()
+	env := expect(t, alice, message.TypeLeave)
+	if env.From != "bob" || env.Room != "go" {
+		t.Errorf("got %+v, want bob leaving go", env)
+	}
+
+	// bob已经被移除，之后的广播不会再发给它
+	send(t, alice, message.Envelope{Type: message.TypeMessage, Room: "go", Body: "still here"})
+	if env := expect(t, alice, message.TypeMessage); env.Body != "still here" {
+		t.Errorf("got %+v", env)
+	}
+}
+
+// next 读取下一条消息，不跳过任何类型
+func next(t *testing.T, conn *websocket.Conn) message.Envelope {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var env message.Envelope
+	if err := conn.ReadJSON(&env); err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestHubReplay(t *testing.T) {
+	history, err := openHistory(filepath.Join(t.TempDir(), "history.jsonl"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+	url := newHistoryServer(t, DefaultConfig, history)
+
+	alice := dial(t, url, "alice")
+	join(t, alice, "go")
+	var seqs []uint64
+	for _, body := range []string{"one", "two", "three"} {
+		send(t, alice, message.Envelope{Type: message.TypeMessage, Room: "go", Body: body})
+		seqs = append(seqs, expect(t, alice, message.TypeMessage).Seq)
+	}
+
+	// bob见过第一条消息，重新加入时先收到之后错过的消息，然后才是实时消息
+	bob := dial(t, url, "bob")
+	send(t, bob, message.Envelope{Type: message.TypeJoin, Room: "go", Seq: seqs[0]})
+	env := next(t, bob)
+	if env.Type != message.TypeHistory {
+		t.Fatalf("first message is %s, want history", env.Type)
+	}
+	if env.Seq != seqs[2] {
+		t.Errorf("history seq = %d, want %d", env.Seq, seqs[2])
+	}
+	if len(env.History) != 2 || env.History[0].Body != "two" || env.History[1].Body != "three" {
+		t.Errorf("history = %+v, want two and three", env.History)
+	}
+	if env := next(t, bob); env.Type != message.TypeJoin || env.From != "bob" {
+		t.Errorf("got %+v, want bob's join", env)
+	}
+	send(t, alice, message.Envelope{Type: message.TypeMessage, Room: "go", Body: "four"})
+	if env := next(t, bob); env.Type != message.TypeMessage || env.Body != "four" || env.Seq != seqs[2]+1 {
+		t.Errorf("got %+v, want live message four", env)
+	}
+}
+
+func TestHubForgedFields(t *testing.T) {
+	history, err := openHistory(filepath.Join(t.TempDir(), "history.jsonl"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+	url := newHistoryServer(t, DefaultConfig, history)
+	alice := dial(t, url, "alice")
+	join(t, alice, "go")
+
+	forged := []message.Envelope{{Type: message.TypeMessage, From: "mallory", Body: "fake"}}
+	send(t, alice, message.Envelope{Type: message.TypeMessage, Room: "go", Body: "hi", Seq: 99, History: forged})
+	// 广播的就是写入history的同一个env
+	env := expect(t, alice, message.TypeMessage)
+	if len(env.History) != 0 || env.Seq != 1 {
+		t.Errorf("got %+v, want seq 1 without history", env)
+	}
+	send(t, alice, message.Envelope{Type: message.TypeTyping, Room: "go", Seq: 99, History: forged})
+	if env := expect(t, alice, message.TypeTyping); len(env.History) != 0 || env.Seq != 0 {
+		t.Errorf("got %+v, want typing without seq and history", env)
+	}
+}
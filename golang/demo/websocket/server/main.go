@@ -1,38 +1,49 @@
 package main
 
 import (
-	"fmt"
-	"github.com/gorilla/websocket"
+	"flag"
 	"log"
 	"net/http"
+
+	"github.com/gorilla/websocket"
 )
 
-var UP websocket.Upgrader
-var conns []*websocket.Conn
+var UP = websocket.Upgrader{Subprotocols: []string{tokenProtocol}}
 
 // 服务器端websocket
 func main() {
-	http.HandleFunc("/", handler)
-	http.ListenAndServe(":8090", nil)
-}
-
-func handler(rep http.ResponseWriter, req *http.Request) {
-	conn, err := UP.Upgrade(rep, req, nil)
-	if err != nil {
-		fmt.Println(err)
-		return
+	cfg := DefaultConfig
+	flag.DurationVar(&cfg.PingInterval, "ping", cfg.PingInterval, "interval between pings")
+	flag.DurationVar(&cfg.PongWait, "pong-wait", cfg.PongWait, "disconnect clients silent for this long (must exceed -ping)")
+	flag.DurationVar(&cfg.WriteWait, "write-wait", cfg.WriteWait, "write timeout for a single message")
+	flag.Int64Var(&cfg.MaxMessageSize, "max-message", cfg.MaxMessageSize, "maximum size in bytes of a client message")
+	flag.IntVar(&cfg.SendQueue, "queue", cfg.SendQueue, "messages buffered per client")
+	flag.Var(&cfg.Slow, "slow", "policy for slow consumers: disconnect or drop-oldest")
+	key := flag.String("jwt-key", "AllYourbase", "HMAC key used to verify client tokens")
+	historyFile := flag.String("history", "", "file that stores chat messages, e.g. history.jsonl (empty disables history)")
+	flag.IntVar(&cfg.ReplayLimit, "replay", cfg.ReplayLimit, "maximum messages replayed when joining a room")
+	flag.Parse()
+	cfg.JWTKey = []byte(*key)
+	if cfg.PingInterval >= cfg.PongWait {
+		log.Fatal("-ping must be shorter than -pong-wait")
 	}
-	conns = append(conns, conn)
-	for {
-		m, p, e := conn.ReadMessage()
-		if e != nil {
-			break
-		}
-		for i := range conns {
-			conns[i].WriteMessage(websocket.TextMessage, []byte("你说的是"+string(p)+"吗？"))
+	if cfg.SendQueue < 1 {
+		log.Fatal("-queue must be at least 1")
+	}
+
+	var history *History
+	if *historyFile != "" {
+		h, err := openHistory(*historyFile, cfg.ReplayLimit)
+		if err != nil {
+			log.Fatal(err)
 		}
-		defer conn.Close()
-		fmt.Println(m, string(p))
+		history = h
 	}
-	log.Println("服务关闭")
+
+	hub := newHub(cfg, history)
+	go hub.run()
+	http.HandleFunc("/", func(rep http.ResponseWriter, req *http.Request) {
+		serveWs(hub, rep, req)
+	})
+	log.Fatal(http.ListenAndServe(":8090", nil))
 }
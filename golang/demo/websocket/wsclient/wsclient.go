@@ -0,0 +1,151 @@
+// Package wsclient 是demo/websocket服务端的命令行聊天客户端
+package wsclient
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopl.io/demo/websocket/message"
+)
+
+// ErrUnauthorized 表示服务端拒绝了token，重连也没有用
+var ErrUnauthorized = errors.New("wsclient: unauthorized")
+
+// Chat 连接addr并加入room，把in的每一行作为消息发送，收到的消息写到out。
+// 断线后自动重连，in结束时返回nil
+func Chat(addr, room, token string, in io.Reader, out io.Writer) error {
+	u := url.URL{Scheme: "ws", Host: addr, Path: "/"}
+	// token放在子协议字段里传给服务端
+	dl := websocket.Dialer{Subprotocols: []string{"jwt", token}}
+
+	lines := make(chan string)
+	go readLines(in, lines)
+
+	s := &session{room: room, lastSeq: make(map[string]uint64), out: out}
+	for {
+		conn, resp, err := dl.Dial(u.String(), nil)
+		if err != nil {
+			if resp != nil {
+				log.Println(err, resp.Status)
+				if resp.StatusCode == http.StatusUnauthorized {
+					return ErrUnauthorized
+				}
+			} else {
+				log.Println(err)
+			}
+		} else if s.run(conn, lines) {
+			return nil
+		}
+		// 断线后重连，join时带上见过的最大序号，服务端会补发错过的消息
+		log.Println("reconnecting...")
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// session 保存跨连接的状态：当前房间和每个房间见过的最大消息序号
+type session struct {
+	room    string
+	lastSeq map[string]uint64
+	out     io.Writer
+}
+
+// run 处理一个连接，直到连接断开或者标准输入结束，标准输入结束时返回true
+func (s *session) run(conn *websocket.Conn, lines <-chan string) bool {
+	defer conn.Close()
+	incoming := make(chan message.Envelope)
+	go func() {
+		defer close(incoming)
+		for {
+			var env message.Envelope
+			if err := conn.ReadJSON(&env); err != nil {
+				return
+			}
+			incoming <- env
+		}
+	}()
+
+	s.join(conn)
+	for {
+		select {
+		case env, ok := <-incoming:
+			if !ok {
+				return false
+			}
+			s.receive(&env)
+		case line, ok := <-lines:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return true
+			}
+			s.send(conn, line)
+		}
+	}
+}
+
+func (s *session) join(conn *websocket.Conn) {
+	conn.WriteJSON(message.Envelope{Type: message.TypeJoin, Room: s.room, Seq: s.lastSeq[s.room]})
+}
+
+// send 把输入的一行作为消息发送，/join <room>切换房间
+func (s *session) send(conn *websocket.Conn, line string) {
+	if strings.HasPrefix(line, "/join ") {
+		conn.WriteJSON(message.Envelope{Type: message.TypeLeave, Room: s.room})
+		s.room = strings.TrimSpace(strings.TrimPrefix(line, "/join "))
+		s.join(conn)
+		return
+	}
+	conn.WriteJSON(message.Envelope{Type: message.TypeMessage, Room: s.room, Body: line})
+}
+
+func (s *session) receive(env *message.Envelope) {
+	if env.Type == message.TypeHistory {
+		for i := range env.History {
+			s.receive(&env.History[i])
+		}
+	} else {
+		show(s.out, env)
+	}
+	if env.Seq > s.lastSeq[env.Room] {
+		s.lastSeq[env.Room] = env.Seq
+	}
+}
+
+func readLines(in io.Reader, lines chan<- string) {
+	r := bufio.NewReader(in)
+	for {
+		l, _, err := r.ReadLine()
+		if err != nil {
+			close(lines)
+			return
+		}
+		lines <- string(l)
+	}
+}
+
+func show(w io.Writer, env *message.Envelope) {
+	ts := time.Unix(0, env.TS*int64(time.Millisecond)).Format("15:04:05")
+	switch env.Type {
+	case message.TypeJoin:
+		fmt.Fprintf(w, "%s [%s] %s joined\n", ts, env.Room, env.From)
+	case message.TypeLeave:
+		fmt.Fprintf(w, "%s [%s] %s left\n", ts, env.Room, env.From)
+	case message.TypeMessage:
+		fmt.Fprintf(w, "%s [%s] %s: %s\n", ts, env.Room, env.From, env.Body)
+	case message.TypeTyping:
+		fmt.Fprintf(w, "%s [%s] %s is typing...\n", ts, env.Room, env.From)
+	case message.TypeError:
+		fmt.Fprintf(w, "%s error: %s\n", ts, env.Body)
+	default:
+		b, _ := json.Marshal(env)
+		fmt.Fprintln(w, string(b))
+	}
+}